@@ -1,65 +1,215 @@
 package main
 
 import (
-	// "fmt"
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
 	cl "dlsh/utils/cmdline"
 	eu "dlsh/utils/execunit"
 )
 
-func main() {
-	tty := cl.NewTty()
-	tty.GetPrompt()
-	for {
+const usage = "usage: dlsh [-i] [-c command [name [arg ...]] | script [arg ...]]"
+
+// Reads a line for the interactive loop, cont is set for the lines
+// continuing an incomplete command. Reports whether the input ended or the
+// line was interrupted.
+type readLineFunc func(cont bool) (line string, eof, interrupted bool)
+
+// Lines from the line editor, read in raw mode
+func ttyReadLine(tty *cl.Tty) readLineFunc {
+	return func(cont bool) (string, bool, bool) {
+		tty.SetContinuation(cont)
 		tty.ReflectPrompt()
 
 		tty.Raw()
 		line, eof := tty.ReadLine()
-		if eof {
-			tty.DumpHist()
-			return
-		}
 		tty.Restore()
+		return line, eof, tty.Interrupted()
+	}
+}
 
-		line = strings.Trim(line, " \t")
-		tokens := eu.Tokenize(&line)
-		// fmt.Println(tokens, len(tokens))
+// Reads lines until they form a complete command, continuation lines are
+// read with the secondary prompt. With a history each line has its history
+// references expanded, a line that changes is echoed and one that fails to
+// expand drops the command. Returns the command's source, the parsed
+// command and whether the input ended.
+func readCommand(readLine readLineFunc, dlsh *eu.ExecUnit) (src string, prog *eu.Sequence, eof bool, err error) {
+	for cont := false; ; cont = true {
+		line, eof, interrupted := readLine(cont)
+		if eof && !cont {
+			return "", nil, true, nil
+		}
+		if interrupted {
+			return "", nil, false, nil
+		}
 
-		dlsh := eu.NewExecUnit()
-		dlsh.Instructions = eu.Parse(tokens)
-		for _, ins := range dlsh.Instructions {
-			dlsh.Ins = ins
-			cmd := ins.Cmd
-			if cmd.Path == "cd" {
-				if !ins.Chdir() {
-					break
-				}
-				tty.GetPrompt()
-				if ins.InsType != eu.PIPE {
-					continue
-				}
-			} else if cmd.Path == "exit" {
-				tty.DumpHist()
-				return
+		if cont {
+			src += "\n"
+		}
+		if dlsh.History != nil {
+			expanded, changed, err := dlsh.History.Expand(src, line)
+			if err != nil {
+				fmt.Fprintf(dlsh.Stderr, "dlsh: %s\n", err.Error())
+				dlsh.Status = 1
+				return "", nil, false, nil
+			}
+			if changed {
+				fmt.Fprintln(dlsh.Stdout, expanded)
+				line = expanded
 			}
+		}
+		src += line
+		prog, err = dlsh.Parse(src)
+		if !eu.IsIncomplete(err) || eof {
+			return src, prog, false, err
+		}
+	}
+}
 
-			switch ins.InsType {
-			case eu.EXEC:
-				if dlsh.Piped {
-					dlsh.DrainExec()
-				} else {
-					dlsh.Run()
-				}
-			case eu.PIPE:
-				dlsh.ExecPipe()
-			case eu.WAIT:
-				dlsh.DrainPipeline()
+// Without arguments commands are read from the terminal with the line
+// editor. If stdin or stdout isn't a terminal they are read as a script
+// instead, unless -i asks for prompts. A script or -c command runs with the
+// arguments after it as its positional parameters. The shell exits with the
+// status of the last command.
+func main() {
+	dlsh := eu.NewExecUnit()
+	args := os.Args[1:]
+	var command, forceInteractive bool
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		opt := args[0]
+		args = args[1:]
+		if opt == "--" {
+			break
+		}
+		switch opt {
+		case "-c":
+			command = true
+		case "-i":
+			forceInteractive = true
+		default:
+			fmt.Fprintf(os.Stderr, "dlsh: %s: invalid option\n%s\n", opt, usage)
+			os.Exit(2)
+		}
+	}
+
+	switch {
+	case command:
+		if len(args) == 0 {
+			fmt.Fprintln(os.Stderr, "dlsh: -c: option requires an argument")
+			os.Exit(2)
+		}
+		if len(args) > 1 {
+			dlsh.Args = args[1:]
+		}
+		dlsh.RunScript(strings.NewReader(args[0]), "dlsh")
+	case len(args) > 0:
+		fp, err := os.Open(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "dlsh: %s\n", err.Error())
+			os.Exit(127)
+		}
+		dlsh.Args = args
+		dlsh.RunScript(bufio.NewReader(fp), args[0])
+		fp.Close()
+	case forceInteractive || cl.IsTerminal():
+		interactive(dlsh)
+	default:
+		dlsh.RunScript(os.Stdin, "dlsh")
+	}
+	os.Exit(dlsh.Status)
+}
+
+// The read loop of an interactive shell. Without a terminal the prompts are
+// plain and written to stderr, and there is no history. Commands are added
+// to the history as they start, their status once they have run. With the
+// histshare option the entries of other sessions are read before each
+// prompt. SIGINT stops the line being run with status 130, SIGQUIT is
+// ignored; they are caught rather than ignored as commands would inherit
+// that.
+func interactive(dlsh *eu.ExecUnit) {
+	dlsh.Interactive = true
+	dlsh.JobControl = cl.IsTerminal()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGQUIT)
+	go func() {
+		for sig := range sigs {
+			if sig == syscall.SIGINT {
+				dlsh.Interrupt()
 			}
+		}
+	}()
+	var tty *cl.Tty
+	var readLine readLineFunc
+	if cl.IsTerminal() {
+		tty = cl.NewTty()
+		tty.Completer = dlsh.Complete
+		dlsh.History = tty.History()
+		readLine = ttyReadLine(tty)
+	} else {
+		lr := cl.NewLineReader(os.Stdin)
+		lr.Out = os.Stderr
+		readLine = func(cont bool) (string, bool, bool) {
+			line, eof := lr.ReadLine(cont)
+			return line, eof, false
+		}
+	}
+	if err := dlsh.Source(eu.RcPath()); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		fmt.Fprintln(os.Stderr, err.Error())
+	}
 
-			if dlsh.Err != nil {
-				break
+	for !dlsh.Exit {
+		dlsh.Jobs.Notify(os.Stdout)
+		if tty != nil && dlsh.Options["histshare"] {
+			if err := dlsh.History.Read(); err != nil {
+				fmt.Fprintf(os.Stderr, "dlsh: history: %s\n", err.Error())
 			}
 		}
+		if tty != nil {
+			tty.GetPrompt()
+			tty.Status = dlsh.Status
+		}
+
+		src, prog, eof, err := readCommand(readLine, dlsh)
+		if eof {
+			break
+		}
+		if prog == nil && err == nil {
+			// interrupted or dropped
+			continue
+		}
+		// a ^C while the line was read is done with
+		dlsh.Interrupted()
+		var entry *cl.HistEntry
+		if tty != nil {
+			var err error
+			if entry, err = tty.AppendHist(src, dlsh.Pwd()); err != nil {
+				fmt.Fprintf(os.Stderr, "dlsh: history: %s\n", err.Error())
+			}
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			dlsh.Status = 2
+		} else {
+			dlsh.Exec(prog)
+		}
+		if dlsh.Interrupted() {
+			dlsh.Status = 130
+		}
+		if tty != nil {
+			if err := tty.FinishHist(entry, dlsh.Status); err != nil {
+				fmt.Fprintf(os.Stderr, "dlsh: history: %s\n", err.Error())
+			}
+		}
+	}
+	if tty != nil {
+		if err := dlsh.History.Flush(); err != nil {
+			fmt.Fprintf(os.Stderr, "dlsh: history: %s\n", err.Error())
+		}
 	}
 }
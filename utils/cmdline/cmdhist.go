@@ -2,36 +2,159 @@ package cmdline
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	ds "dlsh/utils/datastruct"
 )
 
+// A command of the history along with where, when and how it ran. Entries
+// migrated from the old history file have no metadata, their Time is zero.
+type HistEntry struct {
+	Line     string
+	Time     time.Time
+	Duration time.Duration
+	Status   int
+	Cwd      string
+	Host     string
+	Session  string
+	// number of the entry in its session from 1, 0 if it has none
+	Seq int
+}
+
+// Entries are kept in the trie and in entries at the same index
 type CliHistory struct {
-	trie  *ds.Trie
-	buf   string
-	index uint
-	size  uint
-	base  uint
+	trie    *ds.Trie
+	entries []*HistEntry
+	buf     string
+	index   uint
+	size    uint
+	// entries of this session not yet in the history file, and those in it
+	// whose status and duration aren't
+	pending []*HistEntry
+	updates []*HistEntry
+	// bytes of the history file read so far, the rest was written by
+	// other sessions, and the file they were read from. Write replaces the
+	// file rather than rewriting it, a new file is read from the start.
+	offset int64
+	file   os.FileInfo
+	// the host and session recorded with the entries of this shell, and
+	// the number of the last one
+	host    string
+	session string
+	seq     int
 }
 
 func NewCliHistory() *CliHistory {
 	ptr := new(CliHistory)
 	ptr.trie = ds.NewTrie()
+	ptr.host, _ = os.Hostname()
+	ptr.session = fmt.Sprintf("%x-%d", time.Now().Unix(), os.Getpid())
 	return ptr
 }
 
-func (hist *CliHistory) Append(line string) {
-	if len(strings.Trim(line, " \t")) == 0 {
+// The history file, $XDG_STATE_HOME/dlsh/history
+func HistPath() string {
+	state := os.Getenv("XDG_STATE_HOME")
+	if state == "" {
+		state = filepath.Join(os.Getenv("HOME"), ".local", "state")
+	}
+	return filepath.Join(state, "dlsh", "history")
+}
+
+// The file history was kept in before, as bare lines
+func oldHistPath() string {
+	return filepath.Join(os.Getenv("HOME"), ".dlshrc")
+}
+
+// An entry for line run by this shell from start, its status and duration
+// are filled in by Finish
+func (hist *CliHistory) NewEntry(line string, start time.Time, cwd string) *HistEntry {
+	return &HistEntry{
+		Line:    line,
+		Time:    start,
+		Cwd:     cwd,
+		Host:    hist.host,
+		Session: hist.session,
+	}
+}
+
+func (hist *CliHistory) Add(entry *HistEntry) {
+	if len(strings.Trim(entry.Line, " \t")) == 0 {
 		return
 	}
-	hist.trie.Insert(line)
+	hist.trie.Insert(entry.Line)
+	hist.entries = append(hist.entries, entry)
 	hist.size = uint(hist.trie.Size())
 	hist.index = hist.size
 }
 
+// The entries from the oldest
+func (hist *CliHistory) Entries() []*HistEntry {
+	return hist.entries
+}
+
+// The index of the nearest entry holding query from the one at from on,
+// going back or forward, -1 if there is none. Entries of the line skip are
+// passed over.
+func (hist *CliHistory) SearchFrom(query string, from int, back bool, skip string) int {
+	step := 1
+	if back {
+		step = -1
+	}
+	for i := from; i >= 0 && i < len(hist.entries); i += step {
+		line := hist.entries[i].Line
+		if line != skip && strings.Contains(line, query) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Deletes the entries from first up to but not including last, from the
+// history and the history file
+func (hist *CliHistory) Delete(first, last int) error {
+	for i := last - 1; i >= first; i-- {
+		hist.trie.RemoveAt(uint(i))
+	}
+	deleted := func(entry *HistEntry) bool {
+		return slices.Contains(hist.entries[first:last], entry)
+	}
+	hist.pending = slices.DeleteFunc(hist.pending, deleted)
+	hist.updates = slices.DeleteFunc(hist.updates, deleted)
+	hist.entries = slices.Delete(hist.entries, first, last)
+	hist.size = uint(hist.trie.Size())
+	hist.index = hist.size
+	return hist.Write()
+}
+
+// Empties the history and the history file
+func (hist *CliHistory) Clear() error {
+	hist.trie = ds.NewTrie()
+	hist.entries = nil
+	hist.pending = nil
+	hist.updates = nil
+	hist.size = 0
+	hist.index = 0
+
+	fp, err := lockHist(os.O_RDONLY, syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	return hist.replace("")
+}
+
 func (hist *CliHistory) PrevLine() (string, error) {
 	if hist.index > hist.size || hist.size == 0 {
 		return "", fmt.Errorf("Invalid index: %d", hist.index)
@@ -60,47 +183,367 @@ func (hist *CliHistory) NextLine() (string, error) {
 	return hist.trie.At(hist.index)
 }
 
-func (hist *CliHistory) LoadHist() {
-	fp, err := os.OpenFile(os.Getenv("HOME")+"/.dlshrc", os.O_RDONLY|os.O_CREATE, 0644)
+// Backslashes, tabs and newlines are escaped so that a field never holds
+// the separator and an entry stays on one line
+var histEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`)
+
+func histUnescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// An entry is a line of tab separated fields: the start time in seconds
+// since the epoch, the duration in milliseconds, the exit status, the
+// session, the number of the entry in the session, the host, the working
+// directory and last the command.
+func (entry *HistEntry) String() string {
+	var secs int64
+	if !entry.Time.IsZero() {
+		secs = entry.Time.Unix()
+	}
+	return strings.Join([]string{
+		strconv.FormatInt(secs, 10),
+		strconv.FormatInt(entry.Duration.Milliseconds(), 10),
+		strconv.Itoa(entry.Status),
+		histEscaper.Replace(entry.Session),
+		strconv.Itoa(entry.Seq),
+		histEscaper.Replace(entry.Host),
+		histEscaper.Replace(entry.Cwd),
+		histEscaper.Replace(entry.Line),
+	}, "\t")
+}
+
+// An entry is written as it starts, once it is done a line of the form
+//
+//	=	session	seq	duration	status
+//
+// follows it with the duration and status of the entry seq of session
+func (entry *HistEntry) update() string {
+	return strings.Join([]string{
+		"=",
+		histEscaper.Replace(entry.Session),
+		strconv.Itoa(entry.Seq),
+		strconv.FormatInt(entry.Duration.Milliseconds(), 10),
+		strconv.Itoa(entry.Status),
+	}, "\t")
+}
+
+// Parses an entry written by String, a line without all the fields is
+// taken as a bare command. Entries written before they were numbered have
+// no seq field.
+func ParseHistEntry(line string) *HistEntry {
+	fields := strings.Split(line, "\t")
+	if len(fields) == 7 {
+		fields = slices.Insert(fields, 4, "0")
+	}
+	if len(fields) != 8 {
+		return &HistEntry{Line: histUnescape(line)}
+	}
+	secs, err1 := strconv.ParseInt(fields[0], 10, 64)
+	millis, err2 := strconv.ParseInt(fields[1], 10, 64)
+	status, err3 := strconv.Atoi(fields[2])
+	seq, err4 := strconv.Atoi(fields[4])
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+		return &HistEntry{Line: histUnescape(line)}
+	}
+	entry := &HistEntry{
+		Duration: time.Duration(millis) * time.Millisecond,
+		Status:   status,
+		Session:  histUnescape(fields[3]),
+		Seq:      seq,
+		Host:     histUnescape(fields[5]),
+		Cwd:      histUnescape(fields[6]),
+		Line:     histUnescape(fields[7]),
+	}
+	if secs != 0 {
+		entry.Time = time.Unix(secs, 0)
+	}
+	return entry
+}
+
+// Applies an update line written by update to the entry it names, reports
+// whether line is one
+func (hist *CliHistory) parseUpdate(line string) bool {
+	fields := strings.Split(line, "\t")
+	if len(fields) != 5 || fields[0] != "=" {
+		return false
+	}
+	session := histUnescape(fields[1])
+	seq, err1 := strconv.Atoi(fields[2])
+	millis, err2 := strconv.ParseInt(fields[3], 10, 64)
+	status, err3 := strconv.Atoi(fields[4])
+	if err1 != nil || err2 != nil || err3 != nil || session == hist.session {
+		return true
+	}
+	for i := len(hist.entries) - 1; i >= 0; i-- {
+		if entry := hist.entries[i]; entry.Session == session && entry.Seq == seq {
+			entry.Duration = time.Duration(millis) * time.Millisecond
+			entry.Status = status
+			break
+		}
+	}
+	return true
+}
+
+// Creates the history file with the lines of ~/.dlshrc, which held the
+// history before. Runs only while there is no history file, so only once.
+func migrateHist(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	fp, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Unable to open ~/.dlshrc")
-		return
+		return err
 	}
 	defer fp.Close()
 
-	scanner := bufio.NewScanner(fp)
+	old, err := os.Open(oldHistPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer old.Close()
+
+	writer := bufio.NewWriter(fp)
+	scanner := bufio.NewScanner(old)
 	for scanner.Scan() {
-		hist.Append(scanner.Text())
+		if len(strings.Trim(scanner.Text(), " \t")) == 0 {
+			continue
+		}
+		entry := &HistEntry{Line: scanner.Text()}
+		writer.WriteString(entry.String() + "\n")
 	}
 	if err = scanner.Err(); err != nil {
-		fmt.Fprintf(os.Stderr, "Scanner err: %s", err.Error())
+		return err
+	}
+	return writer.Flush()
+}
+
+// Opens the history file locked with how, LOCK_SH or LOCK_EX. The lock is
+// released when the file is closed. A file replaced while waiting for the
+// lock is opened again.
+func lockHist(flag, how int) (*os.File, error) {
+	path := HistPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	for {
+		fp, err := os.OpenFile(path, flag|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, err
+		}
+		if err = syscall.Flock(int(fp.Fd()), how); err != nil {
+			fp.Close()
+			return nil, err
+		}
+		info, err := fp.Stat()
+		if err == nil {
+			var cur os.FileInfo
+			if cur, err = os.Stat(path); err == nil && os.SameFile(info, cur) {
+				return fp, nil
+			}
+		}
+		fp.Close()
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
 	}
-	hist.base = hist.size
-	hist.index = hist.base
 }
 
-func (hist *CliHistory) DumpHist() {
-	fp, err := os.OpenFile(os.Getenv("HOME")+"/.dlshrc", os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+// Replaces the history file with data, the lock must be held. The new file
+// is renamed over the old one so that the other sessions see that it
+// changed rather than reading on from their offset into it.
+func (hist *CliHistory) replace(data string) error {
+	path := HistPath()
+	fp, err := os.CreateTemp(filepath.Dir(path), ".history-*")
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Unable to open ~/.dlshrc: ", err.Error())
-		return
+		return err
+	}
+	defer os.Remove(fp.Name())
+	_, err = fp.WriteString(data)
+	if cerr := fp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(fp.Name())
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(fp.Name(), path); err != nil {
+		return err
+	}
+	hist.file = info
+	hist.offset = int64(len(data))
+	hist.pending = nil
+	hist.updates = nil
+	return nil
+}
+
+// Identifies an entry of another session, read again from a replaced file
+type histKey struct {
+	session string
+	seq     int
+	time    int64
+	line    string
+}
+
+func (entry *HistEntry) key() histKey {
+	return histKey{entry.Session, entry.Seq, entry.Time.Unix(), entry.Line}
+}
+
+// Loads the history file, the first time from ~/.dlshrc
+func (hist *CliHistory) LoadHist() error {
+	path := HistPath()
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		if err = migrateHist(path); err != nil && !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+	}
+	return hist.Read()
+}
+
+// Adds the entries written to the history file since it was last read,
+// those of other sessions running alongside this one
+func (hist *CliHistory) Read() error {
+	fp, err := lockHist(os.O_RDONLY, syscall.LOCK_SH)
+	if err != nil {
+		return err
 	}
 	defer fp.Close()
+	return hist.readFrom(fp)
+}
 
-	writer := bufio.NewWriter(fp)
-	for i := hist.base; i < hist.size; i++ {
-		line, err := hist.trie.At(i)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "%s", err.Error())
-			return
+func (hist *CliHistory) readFrom(fp *os.File) error {
+	info, err := fp.Stat()
+	if err != nil {
+		return err
+	}
+	// a file replaced by another session, or truncated, is read again
+	// from the start for the entries that aren't there yet
+	var seen map[histKey]bool
+	if hist.file != nil && (!os.SameFile(info, hist.file) || info.Size() < hist.offset) {
+		seen = make(map[histKey]bool, len(hist.entries))
+		for _, entry := range hist.entries {
+			seen[entry.key()] = true
 		}
-		n, err := writer.WriteString(line + "\n")
-		if err != nil {
-			fmt.Fprintf(
-				os.Stderr, "Unable to write to ~/.dlshrc %d / %d : %s", n, len(line)+1, err.Error(),
-			)
-			return
+		hist.offset = 0
+	}
+	hist.file = info
+	if _, err = fp.Seek(hist.offset, io.SeekStart); err != nil {
+		return err
+	}
+	data, err := io.ReadAll(fp)
+	if err != nil {
+		return err
+	}
+	// entries are written whole under the lock, a missing newline would be
+	// left by a crash
+	data = data[:bytes.LastIndexByte(data, '\n')+1]
+	hist.offset += int64(len(data))
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" || hist.parseUpdate(line) {
+			continue
 		}
+		entry := ParseHistEntry(line)
+		// the ones of this session are already there
+		if (entry.Session != "" && entry.Session == hist.session) || seen[entry.key()] {
+			continue
+		}
+		hist.Add(entry)
+	}
+	return nil
+}
+
+// Adds entry as its command starts and appends it to the history file. An
+// entry that can't be written is kept for the next Flush.
+func (hist *CliHistory) Append(entry *HistEntry) error {
+	if len(strings.Trim(entry.Line, " \t")) == 0 {
+		return nil
+	}
+	hist.seq++
+	entry.Seq = hist.seq
+	hist.Add(entry)
+	hist.pending = append(hist.pending, entry)
+	return hist.Flush()
+}
+
+// Records the status of an entry added by Append once its command is done,
+// along with its duration
+func (hist *CliHistory) Finish(entry *HistEntry, status int) error {
+	entry.Duration = time.Since(entry.Time)
+	entry.Status = status
+	// deleted meanwhile, or still to be written whole
+	if !slices.Contains(hist.entries, entry) || slices.Contains(hist.pending, entry) {
+		return nil
+	}
+	hist.updates = append(hist.updates, entry)
+	return hist.Flush()
+}
+
+// Appends the entries not yet written to the history file, and the status
+// of those done since
+func (hist *CliHistory) Flush() error {
+	if len(hist.pending) == 0 && len(hist.updates) == 0 {
+		return nil
+	}
+	fp, err := lockHist(os.O_WRONLY|os.O_APPEND, syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	var b strings.Builder
+	for _, entry := range hist.pending {
+		b.WriteString(entry.String() + "\n")
+	}
+	for _, entry := range hist.updates {
+		b.WriteString(entry.update() + "\n")
+	}
+	if _, err = fp.WriteString(b.String()); err != nil {
+		return err
+	}
+	hist.pending = nil
+	hist.updates = nil
+	return nil
+}
+
+// Replaces the history file with the history, after reading the entries
+// other sessions added to it
+func (hist *CliHistory) Write() error {
+	fp, err := lockHist(os.O_RDONLY, syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	if err = hist.readFrom(fp); err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	for _, entry := range hist.entries {
+		b.WriteString(entry.String() + "\n")
 	}
-	writer.Flush()
+	return hist.replace(b.String())
 }
@@ -0,0 +1,225 @@
+package cmdline
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistEntryString(t *testing.T) {
+	tests := []struct {
+		entry *HistEntry
+		want  string
+	}{
+		{
+			&HistEntry{Line: "ls"},
+			"0\t0\t0\t\t0\t\t\tls",
+		},
+		{
+			&HistEntry{
+				Line: "echo a\tb\nc \\d", Time: time.Unix(1700000000, 0),
+				Duration: 1500 * time.Millisecond, Status: 2, Cwd: "/tmp/a\tb",
+				Host: "host", Session: "s-1", Seq: 7,
+			},
+			"1700000000\t1500\t2\ts-1\t7\thost\t/tmp/a\\tb\techo a\\tb\\nc \\\\d",
+		},
+	}
+	for _, test := range tests {
+		got := test.entry.String()
+		if got != test.want {
+			t.Errorf("got %q, want %q", got, test.want)
+		}
+		if parsed := ParseHistEntry(got); *parsed != *test.entry {
+			t.Errorf("%q parsed as %+v, want %+v", got, parsed, test.entry)
+		}
+	}
+}
+
+func TestParseHistEntry(t *testing.T) {
+	tests := []struct {
+		line string
+		want HistEntry
+	}{
+		// before entries were numbered
+		{"1700000000\t20\t1\ts\thost\t/\tls -l", HistEntry{
+			Line: "ls -l", Time: time.Unix(1700000000, 0), Duration: 20 * time.Millisecond,
+			Status: 1, Session: "s", Host: "host", Cwd: "/",
+		}},
+		{"plain command", HistEntry{Line: "plain command"}},
+		{`a\tb`, HistEntry{Line: "a\tb"}},
+		{"x\t0\t0\ts\t1\th\t/\tls", HistEntry{Line: "x\t0\t0\ts\t1\th\t/\tls"}},
+	}
+	for _, test := range tests {
+		if got := ParseHistEntry(test.line); *got != test.want {
+			t.Errorf("%q: got %+v, want %+v", test.line, got, test.want)
+		}
+	}
+}
+
+func TestHistEscape(t *testing.T) {
+	for _, s := range []string{"", "plain", `a\b`, "tab\there", "new\nline", `\t`, `\\n`, "end\\"} {
+		if got := histUnescape(histEscaper.Replace(s)); got != s {
+			t.Errorf("%q round trips to %q", s, got)
+		}
+	}
+}
+
+// Two sessions sharing a history file, one reads what the other appends
+// and the status it records once its command is done
+func TestHistFile(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	a, b := NewCliHistory(), NewCliHistory()
+	a.session, b.session = "a", "b"
+	if err := a.LoadHist(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.LoadHist(); err != nil {
+		t.Fatal(err)
+	}
+
+	entry := a.NewEntry("sleep 1", time.Now(), "/")
+	if err := a.Append(entry); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.Entries()) != 1 || b.Entries()[0].Line != "sleep 1" || b.Entries()[0].Seq != 1 {
+		t.Fatalf("b read %+v", b.Entries())
+	}
+
+	if err := a.Finish(entry, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.Entries()) != 1 || b.Entries()[0].Status != 3 {
+		t.Fatalf("b read %+v after the status", b.Entries())
+	}
+
+	// blank lines aren't kept, a's own entries aren't read twice
+	a.Append(a.NewEntry("  ", time.Now(), "/"))
+	b.Append(b.NewEntry("echo b", time.Now(), "/"))
+	if err := a.Read(); err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, entry := range a.Entries() {
+		lines = append(lines, entry.Line)
+	}
+	if len(lines) != 2 || lines[0] != "sleep 1" || lines[1] != "echo b" {
+		t.Errorf("a has %q", lines)
+	}
+
+	// deleting rewrites the file with the status merged into the entry
+	if err := a.Delete(1, 2); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(HistPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCliHistory()
+	if err := c.LoadHist(); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Entries()) != 1 || c.Entries()[0].Status != 3 {
+		t.Errorf("history file holds %q", data)
+	}
+}
+
+// A session reading the file after another one replaced it with its
+// history gets the entries it hasn't seen, and no part of one
+func TestHistReplaced(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	a, b := NewCliHistory(), NewCliHistory()
+	a.session, b.session = "a", "b"
+	a.LoadHist()
+	b.LoadHist()
+
+	for _, line := range []string{"one", "two", "three"} {
+		a.Append(a.NewEntry(line, time.Now(), "/"))
+	}
+	if err := b.Read(); err != nil {
+		t.Fatal(err)
+	}
+	a.Append(a.NewEntry("four", time.Now(), "/"))
+	a.Append(a.NewEntry("five", time.Now(), "/"))
+	if err := a.Delete(0, 1); err != nil {
+		t.Fatal(err)
+	}
+	a.Append(a.NewEntry("six", time.Now(), "/"))
+	b.Append(b.NewEntry("seven", time.Now(), "/"))
+	if err := b.Read(); err != nil {
+		t.Fatal(err)
+	}
+
+	var lines []string
+	for _, entry := range b.Entries() {
+		lines = append(lines, entry.Line)
+	}
+	// b's own entry goes in as it runs
+	want := "one two three seven four five six"
+	if strings.Join(lines, " ") != want {
+		t.Errorf("b has %q, want %q", lines, want)
+	}
+}
+
+func TestHistDelete(t *testing.T) {
+	tests := []struct {
+		lines       []string
+		first, last int
+		want        []string
+	}{
+		{[]string{"ls", "echo a", "ls"}, 0, 1, []string{"echo a", "ls"}},
+		{[]string{"ls", "echo a", "ls"}, 0, 3, nil},
+		{[]string{"echo é", "echo 日本", "cat"}, 0, 2, []string{"cat"}},
+		{[]string{"echo é", "echo ée"}, 1, 2, []string{"echo é"}},
+	}
+	for _, test := range tests {
+		t.Setenv("XDG_STATE_HOME", t.TempDir())
+		hist := testHist(test.lines...)
+		if err := hist.Delete(test.first, test.last); err != nil {
+			t.Fatal(err)
+		}
+		// what is left to be suggested
+		var words []string
+		for _, node := range *hist.trie.List() {
+			words = append(words, node.GetString())
+		}
+		slices.Sort(words)
+		want := slices.Compact(slices.Sorted(slices.Values(test.want)))
+		if !slices.Equal(words, want) {
+			t.Errorf("%q less %d-%d: trie holds %q, want %q", test.lines, test.first, test.last, words, want)
+		}
+	}
+}
+
+func TestMigrateHist(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_STATE_HOME", "")
+	if err := os.WriteFile(filepath.Join(home, ".dlshrc"), []byte("ls\n\n  \necho a\tb\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	hist := NewCliHistory()
+	if err := hist.LoadHist(); err != nil {
+		t.Fatal(err)
+	}
+	if HistPath() != filepath.Join(home, ".local", "state", "dlsh", "history") {
+		t.Errorf("history file at %s", HistPath())
+	}
+	entries := hist.Entries()
+	if len(entries) != 2 || entries[0].Line != "ls" || entries[1].Line != "echo a\tb" {
+		t.Fatalf("migrated %+v", entries)
+	}
+	if !entries[0].Time.IsZero() {
+		t.Error("a migrated entry has a time")
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"syscall"
 
 	ansi "dlsh/utils/ansi"
+
+	"golang.org/x/term"
 )
 
 type Cursor struct {
@@ -62,7 +64,11 @@ func (c *Cursor) ReflectInitPosOffset(rowOffset, colOffset int) {
 	fmt.Printf("%s[%d;%dH", ansi.Esc, c.initRow+rowOffset, c.initCol+colOffset)
 }
 
+// Left as it was without a terminal, see GetPos
 func (c *Cursor) GetPos() error {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil
+	}
 	fmt.Print(ansi.Esc + "[6n")
 
 	var buf [32]byte
@@ -84,7 +90,11 @@ func (c *Cursor) GetPos() error {
 	return nil
 }
 
+// The reply to the position query never comes if stdin isn't a terminal
 func GetPos() (int, int) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return 0, 0
+	}
 	fmt.Print(ansi.Esc + "[6n")
 
 	var buf [32]byte
@@ -102,11 +112,9 @@ func GetPos() (int, int) {
 	var x, y int
 	_, err = fmt.Sscanf(string(buf[:n]), "\x1b[%d;%dR", &x, &y)
 	if err != nil {
-		fmt.Printf("\r\n %s, %q\r\n", err.Error(), buf)
 		return 0, 0
 	}
 	return x, y
-
 }
 
 func (c *Cursor) Reset() {
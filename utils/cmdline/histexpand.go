@@ -0,0 +1,384 @@
+package cmdline
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Expands the history references of line, csh style:
+//
+//	!!  !n  !-n  !string  !?string?    events
+//	:0  :n  :^  :$  :*  :n-m  :n-  :n*  words of the event
+//	:h  :t  :r  :e  :s/old/new/  :gs/old/new/    modifiers
+//	^old^new^    !!:s/old/new/ at the start of the line
+//
+// A word designator starting with ^, $ or * needs no colon. Nothing is
+// expanded between single quotes, after a backslash or before a blank, = or
+// (. prev holds the lines of the command read before line, its open quotes
+// carry over. Reports whether anything was expanded.
+func (hist *CliHistory) Expand(prev, line string) (string, bool, error) {
+	quote := openQuote(prev)
+	if quote == 0 && prev == "" && strings.HasPrefix(line, "^") {
+		return hist.quickSubst(line)
+	}
+
+	var b strings.Builder
+	changed := false
+	for i := 0; i < len(line); {
+		c := line[i]
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			}
+		case c == '\\' && i+1 < len(line):
+			b.WriteString(line[i : i+2])
+			i += 2
+			continue
+		case c == '\'' && quote == 0:
+			quote = '\''
+		case c == '"':
+			quote ^= '"'
+		case c == '!' && expandable(line, i, quote):
+			text, n, err := hist.reference(line[i:])
+			if err != nil {
+				return "", false, err
+			}
+			b.WriteString(text)
+			i += n
+			changed = true
+			continue
+		}
+		b.WriteByte(c)
+		i++
+	}
+	return b.String(), changed, nil
+}
+
+// The quote left open at the end of src, 0 if none
+func openQuote(src string) byte {
+	var quote byte
+	for i := 0; i < len(src); i++ {
+		switch c := src[i]; {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			}
+		case c == '\\':
+			i++
+		case c == '\'' && quote == 0:
+			quote = '\''
+		case c == '"':
+			quote ^= '"'
+		}
+	}
+	return quote
+}
+
+// Whether the ! at i starts a reference, $! and ${!name} are parameters
+func expandable(line string, i int, quote byte) bool {
+	if i+1 == len(line) || strings.IndexByte(" \t\n=(", line[i+1]) != -1 {
+		return false
+	}
+	if quote == '"' && line[i+1] == '"' {
+		return false
+	}
+	return !strings.HasSuffix(line[:i], "$") && !strings.HasSuffix(line[:i], "${")
+}
+
+// The line of the last entry
+func (hist *CliHistory) last() (string, bool) {
+	if len(hist.entries) == 0 {
+		return "", false
+	}
+	return hist.entries[len(hist.entries)-1].Line, true
+}
+
+// The most recent line that match accepts
+func (hist *CliHistory) find(match func(line string) bool) (string, bool) {
+	for i := len(hist.entries) - 1; i >= 0; i-- {
+		if match(hist.entries[i].Line) {
+			return hist.entries[i].Line, true
+		}
+	}
+	return "", false
+}
+
+// Expands the reference at the start of s, returns its text and length
+func (hist *CliHistory) reference(s string) (string, int, error) {
+	line, n, ok := hist.event(s)
+	if !ok {
+		return "", 0, fmt.Errorf("%s: event not found", s[:n])
+	}
+
+	text := line
+	// a designator starting with ^, $ or * may leave out the colon
+	start := -1
+	if n < len(s) && strings.IndexByte("^$*", s[n]) != -1 {
+		start = n
+	} else if n+1 < len(s) && s[n] == ':' && strings.IndexByte("0123456789^$*-", s[n+1]) != -1 {
+		start = n + 1
+	}
+	if start != -1 {
+		words := histWords(line)
+		from, to, size, ok := designator(s[start:], len(words))
+		if !ok {
+			return "", 0, fmt.Errorf("%s: bad word specifier", s[:start+max(size, 1)])
+		}
+		text = strings.Join(words[from:to], " ")
+		n = start + size
+	}
+
+	for n+1 < len(s) && s[n] == ':' {
+		var err error
+		var size int
+		text, size, err = modify(text, s[n+1:])
+		if err != nil {
+			return "", 0, err
+		}
+		if size == 0 {
+			break
+		}
+		n += 1 + size
+	}
+	return text, n, nil
+}
+
+// The line s refers to and the length of the event
+func (hist *CliHistory) event(s string) (string, int, bool) {
+	rest := s[1:]
+	switch {
+	case rest[0] == '!':
+		line, ok := hist.last()
+		return line, 2, ok
+	case rest[0] == '?':
+		str, _, closed := strings.Cut(rest[1:], "?")
+		n := 2 + len(str)
+		if closed {
+			n++
+		}
+		line, ok := hist.find(func(line string) bool {
+			return strings.Contains(line, str)
+		})
+		return line, n, ok
+	case strings.IndexByte("^$*:", rest[0]) != -1:
+		line, ok := hist.last()
+		return line, 1, ok
+	}
+
+	digits := 0
+	if rest[0] == '-' {
+		digits++
+	}
+	for digits < len(rest) && rest[digits] >= '0' && rest[digits] <= '9' {
+		digits++
+	}
+	if num, err := strconv.Atoi(rest[:digits]); err == nil {
+		if num < 0 {
+			num += len(hist.entries) + 1
+		}
+		if num < 1 || num > len(hist.entries) {
+			return "", 1 + digits, false
+		}
+		return hist.entries[num-1].Line, 1 + digits, true
+	}
+
+	end := strings.IndexAny(rest, " \t\n:;|&<>()'\"")
+	if end == -1 {
+		end = len(rest)
+	}
+	prefix := rest[:end]
+	line, ok := hist.find(func(line string) bool {
+		return strings.HasPrefix(line, prefix)
+	})
+	return line, 1 + end, ok
+}
+
+// Parses a word designator of a line of size words, returns the words
+// from up to but not including to and the length of the designator. A
+// range must hold at least a word.
+func designator(s string, size int) (int, int, int, bool) {
+	number := func(i int) (int, int) {
+		j := i
+		for j < len(s) && s[j] >= '0' && s[j] <= '9' {
+			j++
+		}
+		n, _ := strconv.Atoi(s[i:j])
+		return n, j
+	}
+
+	var from, i int
+	switch s[0] {
+	case '^':
+		from, i = 1, 1
+	case '$':
+		from, i = size-1, 1
+	case '*':
+		return min(1, size), size, 1, true
+	case '-':
+		from, i = 0, 0
+	default:
+		from, i = number(0)
+	}
+
+	to := from + 1
+	if i < len(s) && s[i] == '*' {
+		to = size
+		i++
+	} else if i < len(s) && s[i] == '-' {
+		i++
+		switch {
+		case i < len(s) && s[i] == '$':
+			to = size
+			i++
+		case i < len(s) && s[i] >= '0' && s[i] <= '9':
+			var last int
+			last, i = number(i)
+			to = last + 1
+		default:
+			// n- leaves out the last word
+			to = size - 1
+		}
+	}
+	return from, to, i, from >= 0 && from < to && to <= size
+}
+
+// Splits a line into words as the shell would, quotes are kept and
+// operators are words of their own
+func histWords(line string) []string {
+	var words []string
+	var word strings.Builder
+	var quote byte
+	flush := func() {
+		if word.Len() > 0 {
+			words = append(words, word.String())
+			word.Reset()
+		}
+	}
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' && i+1 < len(line) {
+				word.WriteByte(c)
+				i++
+				c = line[i]
+			}
+		case c == '\\' && i+1 < len(line):
+			word.WriteByte(c)
+			i++
+			c = line[i]
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ' ' || c == '\t' || c == '\n':
+			flush()
+			continue
+		case strings.IndexByte("|&;<>()", c) != -1:
+			flush()
+			j := i
+			for j+1 < len(line) && strings.IndexByte("|&;<>", line[j+1]) != -1 && c != '(' && c != ')' {
+				j++
+			}
+			words = append(words, line[i:j+1])
+			i = j
+			continue
+		}
+		word.WriteByte(c)
+	}
+	flush()
+	return words
+}
+
+// Applies the modifier at the start of s to text, returns the length of
+// the modifier, 0 if s doesn't start with one
+func modify(text, s string) (string, int, error) {
+	switch s[0] {
+	case 'h':
+		if i := strings.LastIndexByte(text, '/'); i > 0 {
+			text = text[:i]
+		} else if i == 0 {
+			text = "/"
+		}
+		return text, 1, nil
+	case 't':
+		return text[strings.LastIndexByte(text, '/')+1:], 1, nil
+	case 'r', 'e':
+		dot := strings.LastIndexByte(text, '.')
+		if dot == -1 || dot < strings.LastIndexByte(text, '/') {
+			if s[0] == 'e' {
+				return "", 1, nil
+			}
+			return text, 1, nil
+		}
+		if s[0] == 'e' {
+			return text[dot:], 1, nil
+		}
+		return text[:dot], 1, nil
+	case 's':
+		return substitute(text, s, false)
+	case 'g':
+		if len(s) > 1 && s[1] == 's' {
+			text, n, err := substitute(text, s[1:], true)
+			return text, n + 1, err
+		}
+	}
+	return text, 0, nil
+}
+
+// Applies s/old/new/, any character following the s is the delimiter. The
+// last delimiter may be left out at the end of the line, an & in new stands
+// for old.
+func substitute(text, s string, global bool) (string, int, error) {
+	if len(s) < 2 {
+		return "", 0, fmt.Errorf(":%s: bad substitution", s)
+	}
+	delim := s[1]
+	i := 2
+	field := func() string {
+		var b strings.Builder
+		for i < len(s) && s[i] != delim {
+			if s[i] == '\\' && i+1 < len(s) && s[i+1] == delim {
+				i++
+			}
+			b.WriteByte(s[i])
+			i++
+		}
+		if i < len(s) {
+			i++
+		}
+		return b.String()
+	}
+	old := field()
+	repl := strings.ReplaceAll(field(), "&", old)
+	if old == "" || !strings.Contains(text, old) {
+		return "", 0, fmt.Errorf(":%s: substitution failed", s[:i])
+	}
+	if global {
+		return strings.ReplaceAll(text, old, repl), i, nil
+	}
+	return strings.Replace(text, old, repl, 1), i, nil
+}
+
+// ^old^new^ substitutes in the last line, what follows is appended
+func (hist *CliHistory) quickSubst(line string) (string, bool, error) {
+	last, ok := hist.last()
+	if !ok {
+		return "", false, fmt.Errorf("%s: event not found", line)
+	}
+	parts := strings.SplitN(line[1:], "^", 3)
+	old := parts[0]
+	var repl, rest string
+	if len(parts) > 1 {
+		repl = parts[1]
+	}
+	if len(parts) > 2 {
+		rest = parts[2]
+	}
+	if old == "" || !strings.Contains(last, old) {
+		return "", false, fmt.Errorf("%s: substitution failed", line)
+	}
+	return strings.Replace(last, old, repl, 1) + rest, true, nil
+}
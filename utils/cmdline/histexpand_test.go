@@ -0,0 +1,156 @@
+package cmdline
+
+import "testing"
+
+func testHist(lines ...string) *CliHistory {
+	hist := NewCliHistory()
+	for _, line := range lines {
+		hist.Add(&HistEntry{Line: line})
+	}
+	return hist
+}
+
+func TestExpand(t *testing.T) {
+	hist := testHist(
+		"ls -l /usr/lib/libc.so.6",
+		"echo one two three",
+		"cat 'a b' | grep x",
+		"vi notes.txt",
+	)
+	tests := []struct {
+		prev, line, want string
+		changed          bool
+	}{
+		{"", "echo", "echo", false},
+		{"", "!!", "vi notes.txt", true},
+		{"", "x !! y", "x vi notes.txt y", true},
+		{"", "!1", "ls -l /usr/lib/libc.so.6", true},
+		{"", "!-2", "cat 'a b' | grep x", true},
+		{"", "!ec", "echo one two three", true},
+		{"", "!?grep?", "cat 'a b' | grep x", true},
+		{"", "!?two", "echo one two three", true},
+		{"", "!echo:2", "two", true},
+		{"", "!echo:0", "echo", true},
+		{"", "!echo:^", "one", true},
+		{"", "!echo:$", "three", true},
+		{"", "!echo:1-2", "one two", true},
+		{"", "!echo:2-", "two", true},
+		{"", "!echo:2*", "two three", true},
+		{"", "!echo:-2", "echo one two", true},
+		{"", "!cat:1", "'a b'", true},
+		{"", "!cat:2", "|", true},
+		{"", "!$", "notes.txt", true},
+		{"", "!^", "notes.txt", true},
+		{"", "!*", "notes.txt", true},
+		{"", "!$:r", "notes", true},
+		{"", "!$:e", ".txt", true},
+		{"", "!1:2:h", "/usr/lib", true},
+		{"", "!1:2:t", "libc.so.6", true},
+		{"", "!1:2:t:r:r", "libc", true},
+		{"", "!!:s/notes/todo/", "vi todo.txt", true},
+		{"", "!echo:gs/o/0/", "ech0 0ne tw0 three", true},
+		{"", "!echo:s/one/[&]/", "echo [one] two three", true},
+		{"", "^notes^todo^", "vi todo.txt", true},
+		{"", "^notes^todo^ -R", "vi todo.txt -R", true},
+		{"", "^notes^todo", "vi todo.txt", true},
+		{"", "echo '!!'", "echo '!!'", false},
+		{"", `echo \!!`, `echo \!!`, false},
+		{"", `echo "!!"`, `echo "vi notes.txt"`, true},
+		{"", "echo ! x", "echo ! x", false},
+		{"", "echo a!= b!(", "echo a!= b!(", false},
+		{"", "echo $! ${!x}", "echo $! ${!x}", false},
+		{"echo 'a", "!! b'", "!! b'", false},
+		{"echo 'a", "b' !!", "b' vi notes.txt", true},
+	}
+	for _, test := range tests {
+		got, changed, err := hist.Expand(test.prev, test.line)
+		if err != nil {
+			t.Errorf("%q: %v", test.line, err)
+		} else if got != test.want || changed != test.changed {
+			t.Errorf("%q: got %q, %v, want %q, %v", test.line, got, changed, test.want, test.changed)
+		}
+	}
+}
+
+func TestExpandErrors(t *testing.T) {
+	hist := testHist("echo one two")
+	tests := []struct {
+		line, err string
+	}{
+		{"!5", "!5: event not found"},
+		{"!-2", "!-2: event not found"},
+		{"!nope", "!nope: event not found"},
+		{"!?nope?", "!?nope?: event not found"},
+		{"!!:9", "!!:9: bad word specifier"},
+		{"!!:2-1", "!!:2-1: bad word specifier"},
+		{"!!:s/x/y/", ":s/x/y/: substitution failed"},
+		{"^x^y", "^x^y: substitution failed"},
+	}
+	for _, test := range tests {
+		_, _, err := hist.Expand("", test.line)
+		if err == nil {
+			t.Errorf("%q: no error", test.line)
+		} else if err.Error() != test.err {
+			t.Errorf("%q: got %q, want %q", test.line, err, test.err)
+		}
+	}
+
+	if _, _, err := testHist().Expand("", "!!"); err == nil {
+		t.Error("!! expanded without a history")
+	}
+}
+
+func TestDesignator(t *testing.T) {
+	tests := []struct {
+		s           string
+		from, to, n int
+		ok          bool
+	}{
+		{"0", 0, 1, 1, true},
+		{"3", 3, 4, 1, true},
+		{"12", 12, 13, 2, false},
+		{"^", 1, 2, 1, true},
+		{"$", 3, 4, 1, true},
+		{"*", 1, 4, 1, true},
+		{"1-2", 1, 3, 3, true},
+		{"1-$", 1, 4, 3, true},
+		{"1-", 1, 3, 2, true},
+		{"-2", 0, 3, 2, true},
+		{"2*", 2, 4, 2, true},
+		{"3-1", 3, 2, 3, false},
+		{"1:h", 1, 2, 1, true},
+	}
+	for _, test := range tests {
+		from, to, n, ok := designator(test.s, 4)
+		if ok != test.ok || (ok && (from != test.from || to != test.to || n != test.n)) {
+			t.Errorf("designator(%q) = %d, %d, %d, %v, want %d, %d, %d, %v", test.s,
+				from, to, n, ok, test.from, test.to, test.n, test.ok)
+		}
+	}
+}
+
+func TestHistWords(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"a  b", []string{"a", "b"}},
+		{"a 'b c' \"d e\"", []string{"a", "'b c'", `"d e"`}},
+		{`a\ b c`, []string{`a\ b`, "c"}},
+		{"a|b&&c;d>e", []string{"a", "|", "b", "&&", "c", ";", "d", ">", "e"}},
+		{"(a)", []string{"(", "a", ")"}},
+	}
+	for _, test := range tests {
+		got := histWords(test.line)
+		if len(got) != len(test.want) {
+			t.Errorf("%q: got %q, want %q", test.line, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%q: got %q, want %q", test.line, got, test.want)
+				break
+			}
+		}
+	}
+}
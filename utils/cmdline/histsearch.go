@@ -0,0 +1,178 @@
+package cmdline
+
+import (
+	"fmt"
+	"strings"
+
+	ansi "dlsh/utils/ansi"
+	key "dlsh/utils/keys"
+)
+
+// An incremental search of the history, shown on the row under the input.
+// The input is left alone until the match is accepted.
+type histSearch struct {
+	query string
+	// searching towards older entries, Ctrl-R, or newer ones, Ctrl-S
+	back bool
+	// index of the entry matched, -1 for none
+	match int
+	// set when the query is no longer found, match is the last one that was
+	failed bool
+	// row the search is drawn on
+	row int
+}
+
+// Starts a search with Ctrl-R or Ctrl-S
+func (tty *Tty) StartSearch(back bool) {
+	tty.NilSuggestions()
+	tty.search = &histSearch{back: back, match: -1}
+}
+
+// Handles a key read while searching. Reports whether the key was used by
+// the search, the others end it and are then handled as usual.
+func (tty *Tty) handleSearch() bool {
+	input := tty.Inp
+	search := tty.search
+	switch {
+	case input.hasCSI:
+		// arrow keys accept the match for editing
+		tty.EndSearch(true)
+		return false
+	case input.finalByte == key.Escape || input.finalByte == key.CtrlG:
+		tty.EndSearch(false)
+	case input.finalByte == key.CtrlC:
+		tty.EndSearch(false)
+		return false
+	case input.Esc:
+		tty.EndSearch(true)
+		return false
+	case input.finalByte == key.CtrlR:
+		tty.searchStep(true)
+	case input.finalByte == key.CtrlS:
+		tty.searchStep(false)
+	case input.finalByte == key.Backspace:
+		if search.query != "" {
+			search.query = search.query[:len(search.query)-1]
+			search.match = -1
+			tty.searchFrom(tty.searchStart(), "")
+		}
+	case input.finalByte >= ' ' && input.finalByte < key.Backspace:
+		search.query += string(input.finalByte)
+		from := search.match
+		if from == -1 {
+			from = tty.searchStart()
+		}
+		tty.searchFrom(from, "")
+	default:
+		tty.EndSearch(true)
+		return false
+	}
+	return true
+}
+
+// Steps to the next match in the direction of back, an empty query takes
+// the one of the last search
+func (tty *Tty) searchStep(back bool) {
+	search := tty.search
+	if search.query == "" {
+		if tty.lastQuery == "" {
+			return
+		}
+		search.query = tty.lastQuery
+	}
+	search.back = back
+
+	from := tty.searchStart()
+	if search.match != -1 && back {
+		from = search.match - 1
+	} else if search.match != -1 {
+		from = search.match + 1
+	}
+	skip := ""
+	if search.match != -1 {
+		skip = tty.hist.entries[search.match].Line
+	}
+	tty.searchFrom(from, skip)
+}
+
+// Where a search without a match starts, at the newest entry going back
+// and at the oldest going forward
+func (tty *Tty) searchStart() int {
+	if tty.search.back {
+		return len(tty.hist.entries) - 1
+	}
+	return 0
+}
+
+// Looks for the query from the entry at from on, entries of the line skip
+// are passed over so that stepping doesn't stop at repeats
+func (tty *Tty) searchFrom(from int, skip string) {
+	search := tty.search
+	if search.query == "" {
+		search.match = -1
+		search.failed = false
+		return
+	}
+	if i := tty.hist.SearchFrom(search.query, from, search.back, skip); i != -1 {
+		search.match = i
+		search.failed = false
+	} else {
+		search.failed = true
+	}
+}
+
+// Ends the search, accept puts the match in the input
+func (tty *Tty) EndSearch(accept bool) {
+	search := tty.search
+	if search == nil {
+		return
+	}
+	if accept && search.match != -1 {
+		tty.Inp.SetBfrToStr(tty.hist.entries[search.match].Line)
+		tty.hist.index = tty.hist.size
+	}
+	if search.query != "" {
+		tty.lastQuery = search.query
+	}
+	if search.row != 0 {
+		tty.Cur.ReflectPosAt(search.row, 1)
+		tty.ClearLine(EntireLine)
+	}
+	tty.search = nil
+}
+
+// Draws the query and the match on the row under the input, the part of
+// the match holding the query is inverted. The cursor is left after the
+// query.
+func (tty *Tty) DrawSearch() {
+	search := tty.search
+	if search == nil {
+		return
+	}
+	label := "reverse-i-search"
+	if !search.back {
+		label = "i-search"
+	}
+	if search.failed {
+		label = "failing " + label
+	}
+	prefix := "(" + label + ")`" + search.query
+	search.row = tty.Cur.initRow + tty.sizeY
+
+	fmt.Print(ansi.CursorHide)
+	tty.Cur.ReflectPosAt(search.row, 1)
+	tty.ClearLine(EntireLine)
+	fmt.Print(prefix + "': ")
+	if search.match != -1 {
+		line := []rune(strings.ReplaceAll(tty.hist.entries[search.match].Line, "\n", "↵"))
+		width := max(tty.dimX-len(prefix)-4, 0)
+		text := string(line[:min(len(line), width)])
+		if i := strings.Index(text, search.query); i != -1 {
+			end := i + len(search.query)
+			text = text[:i] + ansi.Invert + text[i:end] + ansi.Reset + text[end:]
+		}
+		fmt.Print(text)
+	}
+	fmt.Print(ansi.CursorShow)
+	tty.Cur.ReflectPosAt(search.row, len([]rune(prefix))+1)
+}
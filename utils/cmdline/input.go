@@ -130,6 +130,10 @@ func (inp *Input) ReadEOF() bool {
 	return inp.finalByte == key.CtrlD
 }
 
+func (inp *Input) ReadInterrupt() bool {
+	return inp.finalByte == key.CtrlC
+}
+
 // I like this but its unconventional, gotta see the diff in PERF
 // NOTE: copy(dest, src) copies min(len(dest), len(src)) bytes
 // keep len(dest) > 0
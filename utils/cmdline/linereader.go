@@ -0,0 +1,62 @@
+package cmdline
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// Reports whether stdin and stdout are both terminals, the line editor
+// needs them
+func IsTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// LineReader reads lines without the line editor, for an input or output
+// that isn't a terminal. Nothing but the prompts is written, and those only
+// when Out is set. Input is read a byte at a time so that the commands run
+// in between find the rest of it.
+type LineReader struct {
+	in         io.Reader
+	Out        io.Writer
+	Prompt     string
+	ContPrompt string
+}
+
+func NewLineReader(in io.Reader) *LineReader {
+	lr := new(LineReader)
+	lr.in = in
+	lr.Prompt = "dlsh$ "
+	lr.ContPrompt = "> "
+	return lr
+}
+
+// ReadLine returns the next line without its newline and whether the input
+// ended, cont selects the continuation prompt
+func (lr *LineReader) ReadLine(cont bool) (string, bool) {
+	if lr.Out != nil {
+		if cont {
+			fmt.Fprint(lr.Out, lr.ContPrompt)
+		} else {
+			fmt.Fprint(lr.Out, lr.Prompt)
+		}
+	}
+
+	var line strings.Builder
+	buf := make([]byte, 1)
+	for {
+		n, err := lr.in.Read(buf)
+		if n > 0 {
+			if buf[0] == '\n' {
+				return line.String(), false
+			}
+			line.WriteByte(buf[0])
+		}
+		if err != nil {
+			return line.String(), line.Len() == 0
+		}
+	}
+}
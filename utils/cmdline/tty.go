@@ -3,12 +3,15 @@ package cmdline
 // WARN: Needs refactor
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"syscall"
+	"time"
 
 	"dlsh/utils/ansi"
 	ds "dlsh/utils/datastruct"
@@ -36,7 +39,6 @@ type Tty struct {
 	Cur      *Cursor
 	hist     *CliHistory
 	match    *Pattern
-	lineIdx  uint
 	sugg     *ds.Heap[*ds.TrieNode]
 	supSugg  bool
 	oldState *term.State
@@ -45,13 +47,25 @@ type Tty struct {
 	dimY     int
 	sizeX    int
 	sizeY    int
+	// reading the continuation lines of an incomplete command
+	cont bool
+	// the incremental search under way, nil if none, and the query of the
+	// last one
+	search    *histSearch
+	lastQuery string
+	// exit status of the last command, the prompt shows it unless it is 0
+	Status int
+	// candidates for completing a word, each one starting with it. command
+	// is set for the word in command position.
+	Completer func(word string, command bool) []string
 
 	winchDone chan bool
 	sigwinch  atomic.Bool
 }
 
-func (tty *Tty) DumpHist() {
-	tty.hist.DumpHist()
+// The history shared with the shell, for the history builtin
+func (tty *Tty) History() *CliHistory {
+	return tty.hist
 }
 
 func NewTty() *Tty {
@@ -59,8 +73,9 @@ func NewTty() *Tty {
 	tty.Inp = NewInput()
 	tty.Cur = new(Cursor)
 	tty.hist = NewCliHistory()
-	tty.hist.LoadHist()
-	tty.lineIdx = 0
+	if err := tty.hist.LoadHist(); err != nil {
+		fmt.Fprintf(os.Stderr, "dlsh: history: %s\n", err.Error())
+	}
 	tty.sugg = nil
 	tty.supSugg = false
 	tty.oldState, tty.err = term.GetState(int(os.Stdin.Fd()))
@@ -228,6 +243,7 @@ func (tty *Tty) ReadLine() (string, bool) {
 
 	for {
 		tty.Draw()
+		tty.DrawSearch()
 		if exit {
 			break
 		}
@@ -251,41 +267,92 @@ func (tty *Tty) ReadLine() (string, bool) {
 
 	tty.ClearSuggestions()
 	fmt.Print("\r\n")
-	tty.hist.Append(input.str)
-	tty.lineIdx++
 	tty.winchDone <- true
 	return input.str, input.ReadEOF()
 }
 
+// Lines are not added to the history by ReadLine, a command spanning
+// several lines is added once it is complete, as it starts in cwd. It is
+// written to the history file right away, FinishHist adds its status.
+func (tty *Tty) AppendHist(line string, cwd string) (*HistEntry, error) {
+	entry := tty.hist.NewEntry(line, time.Now(), cwd)
+	return entry, tty.hist.Append(entry)
+}
+
+func (tty *Tty) FinishHist(entry *HistEntry, status int) error {
+	return tty.hist.Finish(entry, status)
+}
+
+// Reports whether the last ReadLine was ended by Ctrl-C
+func (tty *Tty) Interrupted() bool {
+	return tty.Inp.ReadInterrupt()
+}
+
+// Switches ReflectPrompt to the continuation prompt
+func (tty *Tty) SetContinuation(cont bool) {
+	tty.cont = cont
+}
+
 func (tty *Tty) ClearLine(cl ClearLineMethod) {
 	fmt.Printf("%s[%dK", ansi.Esc, cl)
 }
 
+// The prompt shows the last component of the logical working directory,
+// $PWD as kept by the shell
 func (tty *Tty) GetPrompt() {
 	cwd, err := os.Getwd()
 	if err != nil {
 		fmt.Println("Failed to get current dir")
 		os.Exit(1)
 	}
+	if pwd := os.Getenv("PWD"); strings.HasPrefix(pwd, "/") && sameDir(pwd, cwd) {
+		cwd = pwd
+	}
 	if strings.Contains(cwd, "/") {
 		cwd = cwd[strings.LastIndex(cwd, "/")+1:]
 	}
 	tty.Prompt = cwd
 }
 
+func sameDir(a, b string) bool {
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	return errA == nil && errB == nil && os.SameFile(infoA, infoB)
+}
+
+// The status segment of the prompt, empty after a success
+func (tty *Tty) statusSegment() string {
+	if tty.Status == 0 {
+		return ""
+	}
+	return " " + strconv.Itoa(tty.Status) + " "
+}
+
 func (tty *Tty) ReflectPrompt() {
+	status := tty.statusSegment()
+	if tty.cont {
+		ansi.SetFgRGB(186, 187, 241)
+		fmt.Print(ansi.BoldOn + strings.Repeat(" ", len(tty.Prompt)+2+len(status)) + " > " + ansi.Reset)
+		return
+	}
 	ansi.SetBgRGB(40, 44, 52)
 	ansi.SetFgRGB(186, 187, 241)
 	fmt.Print(ansi.BoldOn)
 	fmt.Print(" " + tty.Prompt + " ")
 	fmt.Print(ansi.Reset)
 
+	if status != "" {
+		ansi.SetBgRGB(40, 44, 52)
+		ansi.SetFgRGB(243, 139, 168)
+		fmt.Print(ansi.BoldOn + status + ansi.Reset)
+	}
+
 	ansi.SetFgRGB(186, 187, 241)
 	fmt.Print(ansi.BoldOn + " ~ " + ansi.Reset)
 }
 
 func (tty *Tty) CalcSuggestions() {
-	if tty.supSugg == false {
+	if tty.supSugg == false && tty.search == nil {
 		tty.sugg = tty.hist.trie.Search(string(tty.Inp.bfr))
 	}
 	tty.supSugg = false
@@ -303,3 +370,38 @@ func (tty *Tty) ClearSuggestions() {
 func (tty *Tty) HushNextSuggestion() {
 	tty.supSugg = true
 }
+
+// Completes the word before the cursor as far as the candidates of Completer
+// agree, a single candidate that isn't a directory also gets a space
+func (tty *Tty) Complete() {
+	if tty.Completer == nil {
+		return
+	}
+	input := tty.Inp
+	idx := input.Index()
+	bfr := input.Bfr()[:idx]
+	start := bytes.LastIndexAny(bfr, " \t|&;<>()") + 1
+	word := string(bfr[start:])
+	before := bytes.TrimRight(bfr[:start], " \t")
+	command := len(before) == 0 || bytes.IndexByte([]byte("|&;("), before[len(before)-1]) != -1
+
+	candidates := tty.Completer(word, command)
+	if len(candidates) == 0 {
+		return
+	}
+	common := candidates[0]
+	for _, candidate := range candidates[1:] {
+		n := 0
+		for n < len(common) && n < len(candidate) && common[n] == candidate[n] {
+			n++
+		}
+		common = common[:n]
+	}
+	if len(candidates) == 1 && !strings.HasSuffix(common, "/") {
+		common += " "
+	}
+	if len(common) > len(word) && strings.HasPrefix(common, word) {
+		input.BfrInsAtCurIdx([]byte(common[len(word):])...)
+		input.SetIndexOffset(len(common) - len(word))
+	}
+}
@@ -7,6 +7,11 @@ import (
 func (tty *Tty) handleInput() (bool, error) {
 	input := tty.Inp
 
+	if tty.search != nil && tty.handleSearch() {
+		tty.HushNextSuggestion()
+		return false, nil
+	}
+
 	// is it Escape Sequecne?
 	if input.hasCSI {
 		tty.HandleEscapeSequence()
@@ -30,6 +35,12 @@ func (tty *Tty) handleInput() (bool, error) {
 		input.Str()
 		tty.NilSuggestions()
 		tty.HushNextSuggestion()
+	case key.Tab:
+		exit = false
+		tty.Complete()
+	case key.CtrlR, key.CtrlS:
+		exit = false
+		tty.StartSearch(input.finalByte == key.CtrlR)
 	case key.Backspace:
 		exit = false
 		if input.Esc {
@@ -81,7 +92,7 @@ func (tty *Tty) ArrowKeyUp() {
 	if hist.size == 0 {
 		return
 	}
-	if hist.index-hist.base == tty.lineIdx {
+	if hist.index == hist.size {
 		hist.buf = input.Str()
 	}
 
@@ -121,7 +132,7 @@ func (tty *Tty) ArrowKeyDown() {
 			nline = hist.buf
 			hist.index = hist.size
 		}
-	} else if hist.index-hist.base == tty.lineIdx-1 {
+	} else if hist.index == hist.size-1 {
 		hist.index++
 	} else {
 		nline, _ = hist.NextLine()
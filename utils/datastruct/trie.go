@@ -3,6 +3,7 @@ package datastruct
 import (
 	"fmt"
 	"slices"
+	"unicode/utf8"
 )
 
 type TrieNode struct {
@@ -119,6 +120,19 @@ func (trie *Trie) Delete(s string) {
 	trieDelHelper(trie, trie.root, s, 0)
 }
 
+// Removes the word at index from the list, the word itself is deleted once
+// no other index holds it
+func (trie *Trie) RemoveAt(index uint) {
+	if index >= uint(len(trie.pList)) {
+		return
+	}
+	node := trie.pList[index]
+	trie.pList = slices.Delete(trie.pList, int(index), int(index)+1)
+	if !slices.Contains(trie.pList, node) {
+		trie.Delete(node.GetString())
+	}
+}
+
 func trieDelHelper(trie *Trie, trieNode *TrieNode, s string, depth int) *TrieNode {
 	if len(s) == 0 {
 		trieNode.word = false
@@ -131,10 +145,11 @@ func trieDelHelper(trie *Trie, trieNode *TrieNode, s string, depth int) *TrieNod
 		return trieNode
 	}
 
-	if child, exists := trieNode.children[rune(s[0])]; exists {
-		child = trieDelHelper(trie, child, s[1:], depth+1)
+	r, size := utf8.DecodeRuneInString(s)
+	if child, exists := trieNode.children[r]; exists {
+		child = trieDelHelper(trie, child, s[size:], depth+1)
 		if child == nil {
-			delete(trieNode.children, rune(s[0]))
+			delete(trieNode.children, r)
 		}
 		if trieNode.IsEmpty() && !trieNode.word {
 			trieNode = nil
@@ -0,0 +1,111 @@
+package execunit
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+// An alias whose text is still being lexed, it ends at offset end of the
+// source
+type activeAlias struct {
+	name string
+	end  int
+}
+
+// Replaces the word tok in the source with the text of its alias, lexing
+// goes on from its start. A word inside the text of an alias is never
+// expanded by that same alias, which stops recursion.
+func (lex *Lexer) alias(tok Token) (string, bool) {
+	value, ok := lex.aliases[tok.Val]
+	if !ok {
+		return "", false
+	}
+	start := tok.Pos.Offset
+	for _, active := range lex.active {
+		if active.name == tok.Val && start < active.end {
+			return "", false
+		}
+	}
+
+	end := start + len(tok.Val)
+	delta := len(value) - len(tok.Val)
+	lex.src = lex.src[:start] + value + lex.src[end:]
+	for i := range lex.active {
+		if lex.active[i].end >= end {
+			lex.active[i].end += delta
+		}
+	}
+	lex.active = append(lex.active, activeAlias{name: tok.Val, end: start + len(value)})
+	lex.pos = tok.Pos
+	return value, true
+}
+
+func IsAliasName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, " \t\n|&;<>()$`\\\"'=/")
+}
+
+// A value single quoted so that the shell reads it back as is
+func quoteSingle(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// alias [-p] [name[=value] ...]
+func (dlsh *ExecUnit) BuiltinAlias(args []string, stdin, stdout, stderr *os.File) int {
+	args = args[1:]
+	if len(args) > 0 && args[0] == "-p" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		for _, name := range slices.Sorted(maps.Keys(dlsh.Aliases)) {
+			fmt.Fprintf(stdout, "alias %s=%s\n", name, quoteSingle(dlsh.Aliases[name]))
+		}
+		return 0
+	}
+
+	status := 0
+	for _, arg := range args {
+		name, value, assign := strings.Cut(arg, "=")
+		if !assign {
+			if value, ok := dlsh.Aliases[name]; ok {
+				fmt.Fprintf(stdout, "alias %s=%s\n", name, quoteSingle(value))
+			} else {
+				fmt.Fprintf(stderr, "alias: %s: not found\n", name)
+				status = 1
+			}
+			continue
+		}
+		if !IsAliasName(name) {
+			fmt.Fprintf(stderr, "alias: `%s': invalid alias name\n", name)
+			status = 1
+			continue
+		}
+		dlsh.Aliases[name] = value
+	}
+	return status
+}
+
+// unalias [-a] name ...
+func (dlsh *ExecUnit) BuiltinUnalias(args []string, stdin, stdout, stderr *os.File) int {
+	args = args[1:]
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, "unalias: usage: unalias [-a] name [name ...]")
+		return 2
+	}
+	if args[0] == "-a" {
+		clear(dlsh.Aliases)
+		return 0
+	}
+	status := 0
+	for _, name := range args {
+		if _, ok := dlsh.Aliases[name]; !ok {
+			fmt.Fprintf(stderr, "unalias: %s: not found\n", name)
+			status = 1
+			continue
+		}
+		delete(dlsh.Aliases, name)
+	}
+	return status
+}
@@ -0,0 +1,426 @@
+package execunit
+
+import (
+	"strconv"
+	"strings"
+)
+
+// String reconstructs the source of a node, normalizing blanks
+type Node interface {
+	Position() Pos
+	String() string
+}
+
+// Raw holds the word as typed, quotes included
+type Word struct {
+	Pos Pos
+	Raw string
+}
+
+// [Fd]Op Target, Fd is -1 when no io number was given. For << and <<- the
+// target is the delimiter and Doc holds the body.
+type Redirect struct {
+	Pos    Pos
+	Fd     int
+	Op     string
+	Target *Word
+	Doc    *HereDoc
+}
+
+// The body of a here-document, filled in by the lexer once the line with
+// the redirection ends
+type HereDoc struct {
+	Pos       Pos
+	Delim     string
+	StripTabs bool
+	// the body isn't expanded if any part of the delimiter was quoted
+	Quoted bool
+	Body   string
+}
+
+type SimpleCommand struct {
+	Pos Pos
+	// NAME=value words before the command name
+	Assigns []*Word
+	Words   []*Word
+	Redirs  []*Redirect
+}
+
+// { Body; } with redirections applied to the whole group
+type BraceGroup struct {
+	Pos    Pos
+	Body   *Sequence
+	Redirs []*Redirect
+}
+
+// ( Body ), run in a subshell so that its changes to the shell don't outlive
+// it
+type Subshell struct {
+	Pos    Pos
+	Body   *Sequence
+	Redirs []*Redirect
+}
+
+// if Cond; then Body; fi, a branch for the if and one for each elif
+type IfClause struct {
+	Pos      Pos
+	Branches []*Branch
+	// nil without an else
+	Else   *Sequence
+	Redirs []*Redirect
+}
+
+type Branch struct {
+	Cond *Sequence
+	Body *Sequence
+}
+
+// while Cond; do Body; done, until loops while Cond fails
+type WhileClause struct {
+	Pos    Pos
+	Until  bool
+	Cond   *Sequence
+	Body   *Sequence
+	Redirs []*Redirect
+}
+
+// for Name in Words; do Body; done, without in the loop goes over "$@"
+type ForClause struct {
+	Pos    Pos
+	Name   string
+	In     bool
+	Words  []*Word
+	Body   *Sequence
+	Redirs []*Redirect
+}
+
+// case Word in pattern) body;; ... esac
+type CaseClause struct {
+	Pos    Pos
+	Word   *Word
+	Items  []*CaseItem
+	Redirs []*Redirect
+}
+
+// pattern | pattern) Body, the body may be empty
+type CaseItem struct {
+	Patterns []*Word
+	Body     *Sequence
+}
+
+// Name() Body, the body is a compound command
+type FuncDef struct {
+	Pos  Pos
+	Name string
+	Body Node
+}
+
+// [!] cmd1 | cmd2 | ..., each command is simple or compound. The status of
+// a negated pipeline is inverted.
+type Pipeline struct {
+	Pos    Pos
+	Negate bool
+	Cmds   []Node
+}
+
+// Left && Right, Left || Right
+type AndOr struct {
+	Pos   Pos
+	Left  Node
+	Op    string
+	Right Node
+}
+
+// Node &
+type Background struct {
+	Pos  Pos
+	Node Node
+}
+
+// Items separated by `;`, `&` or newlines
+type Sequence struct {
+	Pos   Pos
+	Items []Node
+}
+
+func (w *Word) Position() Pos            { return w.Pos }
+func (r *Redirect) Position() Pos        { return r.Pos }
+func (cmd *SimpleCommand) Position() Pos { return cmd.Pos }
+func (bg *BraceGroup) Position() Pos     { return bg.Pos }
+func (sub *Subshell) Position() Pos      { return sub.Pos }
+func (ic *IfClause) Position() Pos       { return ic.Pos }
+func (wc *WhileClause) Position() Pos    { return wc.Pos }
+func (fc *ForClause) Position() Pos      { return fc.Pos }
+func (cc *CaseClause) Position() Pos     { return cc.Pos }
+func (fd *FuncDef) Position() Pos        { return fd.Pos }
+func (p *Pipeline) Position() Pos        { return p.Pos }
+func (ao *AndOr) Position() Pos          { return ao.Pos }
+func (bg *Background) Position() Pos     { return bg.Pos }
+func (seq *Sequence) Position() Pos      { return seq.Pos }
+
+func (w *Word) String() string {
+	return w.Raw
+}
+
+func (r *Redirect) String() string {
+	if r.Fd >= 0 {
+		return strconv.Itoa(r.Fd) + r.Op + r.Target.Raw
+	}
+	return r.Op + r.Target.Raw
+}
+
+func (cmd *SimpleCommand) String() string {
+	var parts []string
+	for _, assign := range cmd.Assigns {
+		parts = append(parts, assign.String())
+	}
+	for _, word := range cmd.Words {
+		parts = append(parts, word.String())
+	}
+	for _, redir := range cmd.Redirs {
+		parts = append(parts, redir.String())
+	}
+	return strings.Join(parts, " ")
+}
+
+func redirsString(redirs []*Redirect) string {
+	var sb strings.Builder
+	for _, redir := range redirs {
+		sb.WriteString(" " + redir.String())
+	}
+	return sb.String()
+}
+
+func (bg *BraceGroup) String() string {
+	return "{ " + bg.Body.terminated() + " }" + redirsString(bg.Redirs)
+}
+
+func (sub *Subshell) String() string {
+	return "( " + sub.Body.String() + " )" + redirsString(sub.Redirs)
+}
+
+func (ic *IfClause) String() string {
+	var sb strings.Builder
+	for i, branch := range ic.Branches {
+		if i == 0 {
+			sb.WriteString("if ")
+		} else {
+			sb.WriteString(" elif ")
+		}
+		sb.WriteString(branch.Cond.terminated() + " then " + branch.Body.terminated())
+	}
+	if ic.Else != nil {
+		sb.WriteString(" else " + ic.Else.terminated())
+	}
+	sb.WriteString(" fi" + redirsString(ic.Redirs))
+	return sb.String()
+}
+
+func (wc *WhileClause) keyword() string {
+	if wc.Until {
+		return "until"
+	}
+	return "while"
+}
+
+func (wc *WhileClause) String() string {
+	return wc.keyword() + " " + wc.Cond.terminated() + " do " + wc.Body.terminated() +
+		" done" + redirsString(wc.Redirs)
+}
+
+func (fc *ForClause) head() string {
+	head := "for " + fc.Name
+	if fc.In {
+		head += " in"
+		for _, word := range fc.Words {
+			head += " " + word.String()
+		}
+	}
+	return head + ";"
+}
+
+func (fc *ForClause) String() string {
+	return fc.head() + " do " + fc.Body.terminated() + " done" + redirsString(fc.Redirs)
+}
+
+func (item *CaseItem) pattern() string {
+	var patterns []string
+	for _, pat := range item.Patterns {
+		patterns = append(patterns, pat.String())
+	}
+	return strings.Join(patterns, " | ") + ")"
+}
+
+func (cc *CaseClause) String() string {
+	var sb strings.Builder
+	sb.WriteString("case " + cc.Word.String() + " in")
+	for _, item := range cc.Items {
+		sb.WriteString(" " + item.pattern() + " ")
+		if len(item.Body.Items) > 0 {
+			sb.WriteString(item.Body.String())
+		}
+		sb.WriteString(";;")
+	}
+	sb.WriteString(" esac" + redirsString(cc.Redirs))
+	return sb.String()
+}
+
+func (fd *FuncDef) String() string {
+	return fd.Name + "() " + fd.Body.String()
+}
+
+func (p *Pipeline) String() string {
+	var parts []string
+	for _, cmd := range p.Cmds {
+		parts = append(parts, cmd.String())
+	}
+	if p.Negate {
+		return "! " + strings.Join(parts, " | ")
+	}
+	return strings.Join(parts, " | ")
+}
+
+func (ao *AndOr) String() string {
+	return ao.Left.String() + " " + ao.Op + " " + ao.Right.String()
+}
+
+func (bg *Background) String() string {
+	return bg.Node.String() + " &"
+}
+
+func (seq *Sequence) String() string {
+	var sb strings.Builder
+	for i, item := range seq.Items {
+		if i > 0 {
+			if _, ok := seq.Items[i-1].(*Background); ok {
+				sb.WriteString(" ")
+			} else {
+				sb.WriteString("; ")
+			}
+		}
+		sb.WriteString(item.String())
+	}
+	return sb.String()
+}
+
+// The sequence followed by `;` unless its last item already ends in `&`, as
+// in a compound command
+func (seq *Sequence) terminated() string {
+	if len(seq.Items) > 0 {
+		if _, ok := seq.Items[len(seq.Items)-1].(*Background); ok {
+			return seq.String()
+		}
+	}
+	return seq.String() + ";"
+}
+
+// Format is String spread over several lines, the way `type` shows a
+// function: the commands of a compound command go on lines of their own,
+// indented by four spaces
+func Format(node Node) string {
+	f := new(formatter)
+	f.node(node)
+	return f.sb.String()
+}
+
+type formatter struct {
+	sb     strings.Builder
+	indent int
+}
+
+func (f *formatter) newline() {
+	f.sb.WriteByte('\n')
+	f.sb.WriteString(strings.Repeat("    ", f.indent))
+}
+
+func (f *formatter) node(node Node) {
+	switch node := node.(type) {
+	case *FuncDef:
+		f.sb.WriteString(node.Name + " () ")
+		f.newline()
+		f.node(node.Body)
+	case *BraceGroup:
+		f.sb.WriteString("{")
+		f.body(node.Body)
+		f.newline()
+		f.sb.WriteString("}" + redirsString(node.Redirs))
+	case *Subshell:
+		f.sb.WriteString("(")
+		f.body(node.Body)
+		f.newline()
+		f.sb.WriteString(")" + redirsString(node.Redirs))
+	case *IfClause:
+		for i, branch := range node.Branches {
+			if i == 0 {
+				f.sb.WriteString("if ")
+			} else {
+				f.newline()
+				f.sb.WriteString("elif ")
+			}
+			f.sb.WriteString(branch.Cond.terminated() + " then")
+			f.body(branch.Body)
+		}
+		if node.Else != nil {
+			f.newline()
+			f.sb.WriteString("else")
+			f.body(node.Else)
+		}
+		f.newline()
+		f.sb.WriteString("fi" + redirsString(node.Redirs))
+	case *WhileClause:
+		f.sb.WriteString(node.keyword() + " " + node.Cond.terminated() + " do")
+		f.body(node.Body)
+		f.newline()
+		f.sb.WriteString("done" + redirsString(node.Redirs))
+	case *ForClause:
+		f.sb.WriteString(node.head() + " do")
+		f.body(node.Body)
+		f.newline()
+		f.sb.WriteString("done" + redirsString(node.Redirs))
+	case *CaseClause:
+		f.sb.WriteString("case " + node.Word.String() + " in")
+		f.indent++
+		for _, item := range node.Items {
+			f.newline()
+			f.sb.WriteString(item.pattern())
+			f.body(item.Body)
+			f.newline()
+			f.sb.WriteString(";;")
+		}
+		f.indent--
+		f.newline()
+		f.sb.WriteString("esac" + redirsString(node.Redirs))
+	case *Pipeline:
+		if node.Negate {
+			f.sb.WriteString("! ")
+		}
+		for i, cmd := range node.Cmds {
+			if i > 0 {
+				f.sb.WriteString(" | ")
+			}
+			f.node(cmd)
+		}
+	case *AndOr:
+		f.node(node.Left)
+		f.sb.WriteString(" " + node.Op + " ")
+		f.node(node.Right)
+	case *Background:
+		f.node(node.Node)
+		f.sb.WriteString(" &")
+	default:
+		f.sb.WriteString(node.String())
+	}
+}
+
+// The items of the body of a compound command, one per line
+func (f *formatter) body(seq *Sequence) {
+	f.indent++
+	for i, item := range seq.Items {
+		f.newline()
+		f.node(item)
+		if _, ok := item.(*Background); !ok && i < len(seq.Items)-1 {
+			f.sb.WriteString(";")
+		}
+	}
+	f.indent--
+}
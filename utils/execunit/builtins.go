@@ -0,0 +1,186 @@
+package execunit
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Builtin is a command run by the shell itself. Its streams are the files
+// the command was given after pipes and redirections.
+type Builtin interface {
+	Name() string
+	Run(dlsh *ExecUnit, args []string, stdin, stdout, stderr *os.File) int
+}
+
+type BuiltinFunc func(dlsh *ExecUnit, args []string, stdin, stdout, stderr *os.File) int
+
+type builtin struct {
+	name string
+	run  BuiltinFunc
+}
+
+func NewBuiltin(name string, run BuiltinFunc) Builtin {
+	return &builtin{name: name, run: run}
+}
+
+func (b *builtin) Name() string {
+	return b.name
+}
+
+func (b *builtin) Run(dlsh *ExecUnit, args []string, stdin, stdout, stderr *os.File) int {
+	return b.run(dlsh, args, stdin, stdout, stderr)
+}
+
+// Builtins by name, consulted before PATH
+var registry = make(map[string]Builtin)
+
+func RegisterBuiltin(b Builtin) {
+	registry[b.Name()] = b
+}
+
+func LookupBuiltin(name string) (Builtin, bool) {
+	b, ok := registry[name]
+	return b, ok
+}
+
+func init() {
+	for name, run := range map[string]BuiltinFunc{
+		":":        (*ExecUnit).BuiltinTrue,
+		"true":     (*ExecUnit).BuiltinTrue,
+		"false":    (*ExecUnit).BuiltinFalse,
+		"exit":     (*ExecUnit).BuiltinExit,
+		"cd":       (*ExecUnit).BuiltinCd,
+		"pwd":      (*ExecUnit).BuiltinPwd,
+		"pushd":    (*ExecUnit).BuiltinPushd,
+		"popd":     (*ExecUnit).BuiltinPopd,
+		"dirs":     (*ExecUnit).BuiltinDirs,
+		"echo":     (*ExecUnit).BuiltinEcho,
+		"jobs":     (*ExecUnit).BuiltinJobs,
+		"fg":       (*ExecUnit).BuiltinFg,
+		"bg":       (*ExecUnit).BuiltinBg,
+		"wait":     (*ExecUnit).BuiltinWait,
+		"disown":   (*ExecUnit).BuiltinDisown,
+		"shopt":    (*ExecUnit).BuiltinShopt,
+		"set":      (*ExecUnit).BuiltinSet,
+		"export":   (*ExecUnit).BuiltinExport,
+		"readonly": (*ExecUnit).BuiltinReadonly,
+		"unset":    (*ExecUnit).BuiltinUnset,
+		"declare":  (*ExecUnit).BuiltinDeclare,
+		"alias":    (*ExecUnit).BuiltinAlias,
+		"unalias":  (*ExecUnit).BuiltinUnalias,
+		"local":    (*ExecUnit).BuiltinLocal,
+		"return":   (*ExecUnit).BuiltinReturn,
+		"type":     (*ExecUnit).BuiltinType,
+		"break":    (*ExecUnit).BuiltinBreak,
+		"continue": (*ExecUnit).BuiltinContinue,
+		"source":   (*ExecUnit).BuiltinSource,
+		"history":  (*ExecUnit).BuiltinHistory,
+		".":        (*ExecUnit).BuiltinSource,
+	} {
+		RegisterBuiltin(NewBuiltin(name, run))
+	}
+}
+
+// What running name would run: "alias", "keyword", "function", "builtin",
+// "file" or "" if it isn't found
+func (dlsh *ExecUnit) CommandType(name string) string {
+	if _, ok := dlsh.Aliases[name]; ok {
+		return "alias"
+	}
+	if slices.Contains(reservedWords, name) {
+		return "keyword"
+	}
+	if _, ok := dlsh.Funcs[name]; ok {
+		return "function"
+	}
+	if _, ok := LookupBuiltin(name); ok {
+		return "builtin"
+	}
+	if path, err := dlsh.lookPath(name, nil); err == nil && isProgram(dlsh.Dir, path) {
+		return "file"
+	}
+	return ""
+}
+
+// Runs the builtin, function or compound command of ins with its files, a
+// command without a name only makes its assignments
+func (dlsh *ExecUnit) RunBuiltin(ins *Instruction) int {
+	switch {
+	case ins.Func != nil:
+		return dlsh.CallFunc(ins.Func, ins.Cmd.Args, ins.Assigns, ins.R, ins.W, ins.E)
+	case ins.Node != nil:
+		return dlsh.RunCompound(ins.Node, ins.R, ins.W, ins.E)
+	case ins.Builtin == nil:
+		return dlsh.Assign(ins.Assigns, ins.substStatus)
+	}
+	return ins.Builtin.Run(dlsh, ins.Cmd.Args, ins.R, ins.W, ins.E)
+}
+
+// Builtins and functions of a pipeline with more than one command run
+// concurrently with the rest of it, in a subshell as their changes must not
+// outlive it. Its processes join the pipeline's group, it stops running
+// commands once the pipeline is interrupted.
+func (dlsh *ExecUnit) StartBuiltin(ins *Instruction) {
+	sub := dlsh.Subshell()
+	sub.Jobs = dlsh.Jobs
+	sub.interrupt = dlsh.interrupt
+	if dlsh.Interactive {
+		sub.outer = dlsh.jobGroup()
+	}
+	files := ins.files
+	ins.files = nil
+	ins.done = make(chan struct{})
+	go func() {
+		ins.Status = sub.RunBuiltin(ins)
+		for _, fp := range files {
+			fp.Close()
+		}
+		close(ins.done)
+	}()
+}
+
+// true, :
+func (dlsh *ExecUnit) BuiltinTrue(args []string, stdin, stdout, stderr *os.File) int {
+	return 0
+}
+
+// false
+func (dlsh *ExecUnit) BuiltinFalse(args []string, stdin, stdout, stderr *os.File) int {
+	return 1
+}
+
+// exit [n], the status defaults to that of the last command
+func (dlsh *ExecUnit) BuiltinExit(args []string, stdin, stdout, stderr *os.File) int {
+	dlsh.Exit = true
+	if len(args) < 2 {
+		return dlsh.Status
+	}
+	n, err := strconv.Atoi(args[1])
+	if err != nil {
+		fmt.Fprintf(stderr, "exit: %s: numeric argument required\n", args[1])
+		return 2
+	}
+	return n & 0xff
+}
+
+// echo [-n] [arg ...]
+func (dlsh *ExecUnit) BuiltinEcho(args []string, stdin, stdout, stderr *os.File) int {
+	args = args[1:]
+	newline := true
+	if len(args) > 0 && args[0] == "-n" {
+		newline = false
+		args = args[1:]
+	}
+	out := strings.Join(args, " ")
+	if newline {
+		out += "\n"
+	}
+	if _, err := stdout.WriteString(out); err != nil {
+		fmt.Fprintln(stderr, "echo: write error: "+err.Error())
+		return 1
+	}
+	return 0
+}
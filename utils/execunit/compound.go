@@ -0,0 +1,240 @@
+package execunit
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// The state of the pipeline being run, a compound command of it runs
+// pipelines of its own in the same shell
+type pipelineState struct {
+	piped        bool
+	r, w         *os.File
+	instructions Instructions
+	ins          *Instruction
+	pipeline     *Pipeline
+	jobPgid      int
+	group        *jobGroup
+}
+
+func (dlsh *ExecUnit) savePipeline() pipelineState {
+	return pipelineState{
+		piped:        dlsh.Piped,
+		r:            dlsh.R,
+		w:            dlsh.W,
+		instructions: dlsh.Instructions,
+		ins:          dlsh.Ins,
+		pipeline:     dlsh.Pipeline,
+		jobPgid:      dlsh.JobPgid,
+		group:        dlsh.group,
+	}
+}
+
+func (dlsh *ExecUnit) restorePipeline(state pipelineState) {
+	dlsh.Piped = state.piped
+	dlsh.R, dlsh.W = state.r, state.w
+	dlsh.Instructions = state.instructions
+	dlsh.Ins = state.ins
+	dlsh.Pipeline = state.pipeline
+	dlsh.JobPgid = state.jobPgid
+	dlsh.group = state.group
+}
+
+// Redirections applied to a compound command as a whole
+func compoundRedirs(node Node) []*Redirect {
+	switch node := node.(type) {
+	case *BraceGroup:
+		return node.Redirs
+	case *Subshell:
+		return node.Redirs
+	case *IfClause:
+		return node.Redirs
+	case *WhileClause:
+		return node.Redirs
+	case *ForClause:
+		return node.Redirs
+	case *CaseClause:
+		return node.Redirs
+	}
+	return nil
+}
+
+// Runs a compound command in the shell with the given standard streams,
+// its own redirections are applied on top of them
+func (dlsh *ExecUnit) RunCompound(node Node, stdin, stdout, stderr *os.File) int {
+	ins := NewInstruction("")
+	ins.Cmd.Dir = dlsh.Dir
+	ins.SetFile(0, stdin)
+	ins.SetFile(1, stdout)
+	ins.SetFile(2, stderr)
+	defer ins.CloseFiles()
+	var err error
+	if ins.Redirs, err = dlsh.expandRedirs(compoundRedirs(node)); err == nil {
+		err = ins.ApplyRedirects()
+	}
+	if err != nil {
+		fmt.Fprintln(ins.E, err.Error())
+		return 1
+	}
+
+	var status int
+	dlsh.withFiles(ins.R, ins.W, ins.E, func() {
+		status = dlsh.Exec(node)
+	})
+	return status
+}
+
+// Runs commands in the middle of the current pipeline with the given
+// standard streams, the state of the pipeline is restored afterwards
+func (dlsh *ExecUnit) withFiles(stdin, stdout, stderr *os.File, run func()) {
+	state := dlsh.savePipeline()
+	saved := [3]*os.File{dlsh.Stdin, dlsh.Stdout, dlsh.Stderr}
+	dlsh.Stdin, dlsh.Stdout, dlsh.Stderr = stdin, stdout, stderr
+	run()
+	dlsh.Stdin, dlsh.Stdout, dlsh.Stderr = saved[0], saved[1], saved[2]
+	dlsh.restorePipeline(state)
+}
+
+func (dlsh *ExecUnit) execIf(clause *IfClause) {
+	for _, branch := range clause.Branches {
+		dlsh.Exec(branch.Cond)
+		if dlsh.unwinding() {
+			return
+		}
+		if dlsh.Status == 0 {
+			dlsh.Exec(branch.Body)
+			return
+		}
+	}
+	if clause.Else != nil {
+		dlsh.Exec(clause.Else)
+		return
+	}
+	dlsh.Status = 0
+}
+
+// Reports whether a loop ends after its condition or body ran. A break or
+// continue given a count stops that many enclosing loops, the last one of
+// them only skips to its next iteration for continue.
+func (dlsh *ExecUnit) loopDone() bool {
+	switch {
+	case dlsh.Break > 0:
+		dlsh.Break--
+		return true
+	case dlsh.Continue > 1:
+		dlsh.Continue--
+		return true
+	case dlsh.Continue == 1:
+		dlsh.Continue = 0
+		return false
+	}
+	return dlsh.unwinding()
+}
+
+// The status of a loop is that of the last body run, 0 if there was none
+func (dlsh *ExecUnit) execWhile(clause *WhileClause) {
+	status := 0
+	dlsh.LoopDepth++
+	defer func() { dlsh.LoopDepth-- }()
+	for {
+		dlsh.Exec(clause.Cond)
+		if dlsh.loopDone() || (dlsh.Status == 0) == clause.Until {
+			break
+		}
+		status = dlsh.Exec(clause.Body)
+		if dlsh.loopDone() {
+			break
+		}
+	}
+	dlsh.Status = status
+}
+
+func (dlsh *ExecUnit) execFor(clause *ForClause) {
+	words := dlsh.Args[1:]
+	if clause.In {
+		words = nil
+		for _, word := range clause.Words {
+			fields, err := dlsh.ExpandFields(word)
+			if err != nil {
+				fmt.Fprintln(dlsh.Stderr, err.Error())
+				dlsh.Status = 1
+				return
+			}
+			words = append(words, fields...)
+		}
+	}
+
+	status := 0
+	dlsh.LoopDepth++
+	defer func() { dlsh.LoopDepth-- }()
+	for _, word := range words {
+		if err := dlsh.Vars.Set(clause.Name, word); err != nil {
+			fmt.Fprintln(dlsh.Stderr, err.Error())
+			status = 1
+			break
+		}
+		status = dlsh.Exec(clause.Body)
+		if dlsh.loopDone() {
+			break
+		}
+	}
+	dlsh.Status = status
+}
+
+// Runs the body of the first item with a pattern matching the word, the
+// quoted parts of a pattern only match themselves
+func (dlsh *ExecUnit) execCase(clause *CaseClause) {
+	word, err := dlsh.Expand(clause.Word)
+	if err != nil {
+		fmt.Fprintln(dlsh.Stderr, err.Error())
+		dlsh.Status = 1
+		return
+	}
+	dlsh.Status = 0
+	for _, item := range clause.Items {
+		for _, pat := range item.Patterns {
+			if Match(dlsh.expandPattern(pat.Raw), word) {
+				dlsh.Exec(item.Body)
+				return
+			}
+		}
+	}
+}
+
+// The number of enclosing loops to leave, 1 by default
+func (dlsh *ExecUnit) loopCount(args []string, stderr *os.File) (int, bool) {
+	if dlsh.LoopDepth == 0 {
+		fmt.Fprintf(stderr, "%s: only meaningful in a `for', `while', or `until' loop\n", args[0])
+		return 0, false
+	}
+	n := 1
+	if len(args) > 1 {
+		var err error
+		if n, err = strconv.Atoi(args[1]); err != nil || n < 1 {
+			fmt.Fprintf(stderr, "%s: %s: loop count out of range\n", args[0], args[1])
+			return 0, false
+		}
+	}
+	return min(n, dlsh.LoopDepth), true
+}
+
+// break [n]
+func (dlsh *ExecUnit) BuiltinBreak(args []string, stdin, stdout, stderr *os.File) int {
+	n, ok := dlsh.loopCount(args, stderr)
+	if !ok {
+		return 1
+	}
+	dlsh.Break = n
+	return 0
+}
+
+// continue [n]
+func (dlsh *ExecUnit) BuiltinContinue(args []string, stdin, stdout, stderr *os.File) int {
+	n, ok := dlsh.loopCount(args, stderr)
+	if !ok {
+		return 1
+	}
+	dlsh.Continue = n
+	return 0
+}
@@ -0,0 +1,44 @@
+package execunit
+
+import "testing"
+
+func TestControlFlow(t *testing.T) {
+	testShell(t, []shellTest{
+		{"if true; then echo a; fi", "a\n", "", 0},
+		{"if false; then echo a; fi; echo $?", "0\n", "", 0},
+		{"if false; then echo a; elif true; then echo b; else echo c; fi", "b\n", "", 0},
+		{"if false; then echo a; elif false; then echo b; else echo c; fi", "c\n", "", 0},
+		{"if false; false; then :; else echo $?; fi", "1\n", "", 0},
+		{"if true\nthen\n  echo a\nfi", "a\n", "", 0},
+		{"if true; then sh -c 'exit 3'; fi; echo $?", "3\n", "", 0},
+		{"x=a; while [ $x != aaa ]; do x=${x}a; done; echo $x", "aaa\n", "", 0},
+		{"x=a; until [ $x = aaa ]; do x=${x}a; done; echo $x", "aaa\n", "", 0},
+		{"while false; do :; done; echo $?", "0\n", "", 0},
+		{"for i in a b 'c d'; do echo $i; done", "a\nb\nc d\n", "", 0},
+		{"set -- a b; for i; do echo $i; done", "a\nb\n", "", 0},
+		{"x='a b'; for i in $x; do echo $i; done", "a\nb\n", "", 0},
+		{"for i in; do echo $i; done; echo $?", "0\n", "", 0},
+		{"for i in a b; do :; done; echo $i", "b\n", "", 0},
+		{"for i in a b c; do [ $i = b ] && continue; echo $i; done", "a\nc\n", "", 0},
+		{"for i in a b c; do [ $i = b ] && break; echo $i; done", "a\n", "", 0},
+		{"for i in a b; do for j in 1 2; do echo $i$j; continue 2; done; done", "a1\nb1\n", "", 0},
+		{"for i in a b; do for j in 1 2; do echo $i$j; break 2; done; done", "a1\n", "", 0},
+		{"while true; do while true; do break 5; done; done; echo a", "a\n", "", 0},
+		{"break", "", "break: only meaningful in a `for', `while', or `until' loop\n", 1},
+		{"for i in a; do break x; done", "", "break: x: loop count out of range\n", 1},
+		{"case abc in a) echo a;; a*) echo b;; *) echo c;; esac", "b\n", "", 0},
+		{"case x in a|x) echo a;; esac", "a\n", "", 0},
+		{"case x in (x) echo a;; esac", "a\n", "", 0},
+		{"case '*' in \\*) echo a;; *) echo b;; esac", "a\n", "", 0},
+		{"x=b; case abc in a${x}c) echo a;; esac", "a\n", "", 0},
+		{"case x in y) echo a;; esac; echo $?", "0\n", "", 0},
+		{"case x in\nx)\n  echo a\n  ;;\nesac", "a\n", "", 0},
+		{"for i in 2 1; do echo $i; done | sort", "1\n2\n", "", 0},
+		{"printf 'a\\nb\\n' | while true; do echo x; break; done", "x\n", "", 0},
+		{"for i in a; do echo $i; done >f; cat f", "a\n", "", 0},
+		{"! true; echo $?; ! false; echo $?", "1\n0\n", "", 0},
+		{"true && echo a || echo b; false && echo a || echo b", "a\nb\n", "", 0},
+		{"{ echo a; echo b; } | wc -l", "2\n", "", 0},
+		{"x=1; (x=2; exit 3); echo $? $x", "3 1\n", "", 0},
+	})
+}
@@ -0,0 +1,480 @@
+package execunit
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// The logical working directory, $PWD if it still names the current
+// directory, symbolic links included
+func (dlsh *ExecUnit) Pwd() string {
+	wd := dlsh.Dir
+	if !dlsh.subshell {
+		var err error
+		if wd, err = unix.Getwd(); err != nil {
+			return ""
+		}
+	}
+	if pwd, ok := dlsh.Vars.Get("PWD"); ok && filepath.IsAbs(pwd) && sameFile(pwd, wd) {
+		return pwd
+	}
+	return wd
+}
+
+func sameFile(a, b string) bool {
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	return errA == nil && errB == nil && os.SameFile(infoA, infoB)
+}
+
+// Resolves name against dir unless it is absolute or dir is empty. It isn't
+// cleaned, `..` after a symbolic link is left to the kernel.
+func inDir(dir, name string) string {
+	if dir == "" || name == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return strings.TrimSuffix(dir, "/") + "/" + name
+}
+
+// Opens name relative to dir, errors report name as it was given
+func openIn(dir, name string, flag int) (*os.File, error) {
+	fp, err := os.OpenFile(inDir(dir, name), flag, 0666)
+	if perr, ok := err.(*os.PathError); ok {
+		perr.Path = name
+	}
+	return fp, err
+}
+
+// Reports why path can't be made the working directory, as chdir(2) would
+func canEnter(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		err = unix.ENOTDIR
+	} else {
+		err = unix.Access(path, unix.X_OK)
+	}
+	if err != nil {
+		return &os.PathError{Op: "chdir", Path: path, Err: err}
+	}
+	return nil
+}
+
+// Changes directory to dir and updates PWD and OLDPWD. A logical dir is
+// resolved against PWD lexically, `..` removes the last component instead of
+// going to the parent of a symbolic link's target. The working directory of
+// the process belongs to the shell, a subshell only changes its Dir.
+func (dlsh *ExecUnit) Chdir(dir string, physical bool) error {
+	old := dlsh.Pwd()
+	path := dir
+	if !physical {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(old, path)
+		}
+		path = filepath.Clean(path)
+	}
+	if dlsh.subshell {
+		path = inDir(dlsh.Dir, path)
+		if err := canEnter(path); err != nil {
+			return err
+		}
+		if physical {
+			path, _ = filepath.EvalSymlinks(path)
+		}
+		dlsh.Dir = path
+	} else {
+		if err := os.Chdir(path); err != nil {
+			return err
+		}
+		if physical {
+			path, _ = unix.Getwd()
+		}
+	}
+
+	dlsh.Vars.Set("OLDPWD", old)
+	dlsh.Vars.Export("OLDPWD", true)
+	dlsh.Vars.Set("PWD", path)
+	dlsh.Vars.Export("PWD", true)
+	return nil
+}
+
+// The reason of a failed path operation without the operation and path
+func pathError(err error) string {
+	if perr, ok := err.(*os.PathError); ok {
+		return perr.Err.Error()
+	}
+	return err.Error()
+}
+
+// Looks a relative dir up in the directories of CDPATH, an empty entry is
+// the current directory. Reports whether an entry of CDPATH was used.
+func (dlsh *ExecUnit) cdPath(dir string) (string, bool) {
+	cdpath, _ := dlsh.Vars.Get("CDPATH")
+	if cdpath == "" || filepath.IsAbs(dir) || dir == "." || dir == ".." ||
+		strings.HasPrefix(dir, "./") || strings.HasPrefix(dir, "../") {
+		return dir, false
+	}
+	for _, entry := range strings.Split(cdpath, ":") {
+		if entry == "" {
+			if isDir(inDir(dlsh.Dir, dir)) {
+				return dir, false
+			}
+			continue
+		}
+		if path := filepath.Join(entry, dir); isDir(inDir(dlsh.Dir, path)) {
+			return path, true
+		}
+	}
+	return dir, false
+}
+
+// Parses the -L and -P flags of cd and pwd, the last one wins
+func physicalFlag(args []string) (bool, []string, error) {
+	physical := false
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") && args[0] != "-" {
+		if args[0] == "--" {
+			return physical, args[1:], nil
+		}
+		for _, opt := range args[0][1:] {
+			switch opt {
+			case 'L':
+				physical = false
+			case 'P':
+				physical = true
+			default:
+				return physical, args, fmt.Errorf("-%c: invalid option", opt)
+			}
+		}
+		args = args[1:]
+	}
+	return physical, args, nil
+}
+
+// cd [-L | -P] [dir | -]
+func (dlsh *ExecUnit) BuiltinCd(args []string, stdin, stdout, stderr *os.File) int {
+	physical, args, err := physicalFlag(args[1:])
+	if err != nil {
+		fmt.Fprintln(stderr, "cd: "+err.Error())
+		return 2
+	}
+	if len(args) > 1 {
+		fmt.Fprintln(stderr, "cd: too many arguments")
+		return 1
+	}
+
+	var dir string
+	print := false
+	switch {
+	case len(args) == 0:
+		home, ok := dlsh.Vars.Get("HOME")
+		if !ok || home == "" {
+			fmt.Fprintln(stderr, "cd: HOME not set")
+			return 1
+		}
+		dir = home
+	case args[0] == "-":
+		old, ok := dlsh.Vars.Get("OLDPWD")
+		if !ok || old == "" {
+			fmt.Fprintln(stderr, "cd: OLDPWD not set")
+			return 1
+		}
+		dir, print = old, true
+	default:
+		dir, print = dlsh.cdPath(args[0])
+	}
+
+	if err := dlsh.Chdir(dir, physical); err != nil {
+		fmt.Fprintf(stderr, "cd: %s: %s\n", dir, pathError(err))
+		return 1
+	}
+	if print {
+		fmt.Fprintln(stdout, dlsh.Pwd())
+	}
+	return 0
+}
+
+// pwd [-L | -P]
+func (dlsh *ExecUnit) BuiltinPwd(args []string, stdin, stdout, stderr *os.File) int {
+	physical, _, err := physicalFlag(args[1:])
+	if err != nil {
+		fmt.Fprintln(stderr, "pwd: "+err.Error())
+		return 2
+	}
+	// os.Getwd trusts $PWD, the physical directory needs getcwd(3)
+	wd := dlsh.Pwd()
+	if physical && dlsh.subshell {
+		wd, err = filepath.EvalSymlinks(dlsh.Dir)
+	} else if physical {
+		wd, err = unix.Getwd()
+	}
+	if err != nil || wd == "" {
+		fmt.Fprintln(stderr, "pwd: cannot determine the current directory")
+		return 1
+	}
+	fmt.Fprintln(stdout, wd)
+	return 0
+}
+
+// The directory stack with the working directory on top, as listed by dirs
+func (dlsh *ExecUnit) Dirs() []string {
+	return append([]string{dlsh.Pwd()}, dlsh.DirStack...)
+}
+
+// Index into Dirs of +N, counted from the top, or -N, counted from the bottom
+func (dlsh *ExecUnit) dirIndex(spec string) (int, bool) {
+	if len(spec) < 2 || (spec[0] != '+' && spec[0] != '-') {
+		return 0, false
+	}
+	n, err := strconv.Atoi(spec[1:])
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	size := len(dlsh.DirStack) + 1
+	if spec[0] == '-' {
+		n = size - 1 - n
+	}
+	return n, n >= 0 && n < size
+}
+
+// The directory named by the prefix of a tilde expansion: HOME for ~,
+// $PWD for ~+, $OLDPWD for ~-, an entry of the directory stack for ~N, ~+N
+// and ~-N, or the home directory of a user
+func (dlsh *ExecUnit) TildeDir(prefix string) (string, bool) {
+	switch prefix {
+	case "":
+		return dlsh.Vars.Get("HOME")
+	case "+":
+		return dlsh.Pwd(), true
+	case "-":
+		return dlsh.Vars.Get("OLDPWD")
+	}
+	spec := prefix
+	if spec[0] >= '0' && spec[0] <= '9' {
+		spec = "+" + spec
+	}
+	if n, ok := dlsh.dirIndex(spec); ok {
+		return dlsh.Dirs()[n], true
+	}
+	if u, err := user.Lookup(prefix); err == nil {
+		return u.HomeDir, true
+	}
+	return "", false
+}
+
+// Replaces a leading $HOME of path with ~
+func (dlsh *ExecUnit) tildeHome(path string) string {
+	home, _ := dlsh.Vars.Get("HOME")
+	if home == "" || home == "/" {
+		return path
+	}
+	if path == home || strings.HasPrefix(path, home+"/") {
+		return "~" + path[len(home):]
+	}
+	return path
+}
+
+func (dlsh *ExecUnit) printDirs(stdout *os.File, long, vertical bool) {
+	for i, dir := range dlsh.Dirs() {
+		if !long {
+			dir = dlsh.tildeHome(dir)
+		}
+		switch {
+		case vertical:
+			fmt.Fprintf(stdout, "%2d  %s\n", i, dir)
+		case i > 0:
+			fmt.Fprint(stdout, " "+dir)
+		default:
+			fmt.Fprint(stdout, dir)
+		}
+	}
+	if !vertical {
+		fmt.Fprintln(stdout)
+	}
+}
+
+// dirs [-clpv] [+N | -N]
+func (dlsh *ExecUnit) BuiltinDirs(args []string, stdin, stdout, stderr *os.File) int {
+	var long, vertical, clear bool
+	for _, arg := range args[1:] {
+		if n, ok := dlsh.dirIndex(arg); ok {
+			dir := dlsh.Dirs()[n]
+			if !long {
+				dir = dlsh.tildeHome(dir)
+			}
+			fmt.Fprintln(stdout, dir)
+			return 0
+		}
+		if !strings.HasPrefix(arg, "-") || strings.Trim(arg[1:], "clpv") != "" {
+			fmt.Fprintf(stderr, "dirs: %s: invalid argument\n", arg)
+			return 1
+		}
+		clear = clear || strings.Contains(arg, "c")
+		long = long || strings.Contains(arg, "l")
+		vertical = vertical || strings.ContainsAny(arg, "pv")
+	}
+	if clear {
+		dlsh.DirStack = nil
+		return 0
+	}
+	dlsh.printDirs(stdout, long, vertical)
+	return 0
+}
+
+// pushd [dir | +N | -N], without arguments the top two directories are
+// swapped. +N rotates the stack so that its Nth directory is on top.
+func (dlsh *ExecUnit) BuiltinPushd(args []string, stdin, stdout, stderr *os.File) int {
+	args = args[1:]
+	if len(args) > 1 {
+		fmt.Fprintln(stderr, "pushd: too many arguments")
+		return 1
+	}
+
+	dirs := dlsh.Dirs()
+	var stack []string
+	switch {
+	case len(args) == 0:
+		if len(dirs) < 2 {
+			fmt.Fprintln(stderr, "pushd: no other directory")
+			return 1
+		}
+		stack = append([]string{dirs[1], dirs[0]}, dirs[2:]...)
+	case len(args[0]) > 1 && (args[0][0] == '+' || args[0][0] == '-'):
+		n, ok := dlsh.dirIndex(args[0])
+		if !ok {
+			fmt.Fprintf(stderr, "pushd: %s: directory stack index out of range\n", args[0])
+			return 1
+		}
+		stack = append(dirs[n:], dirs[:n]...)
+	default:
+		stack = append([]string{args[0]}, dirs...)
+	}
+
+	if err := dlsh.Chdir(stack[0], false); err != nil {
+		fmt.Fprintf(stderr, "pushd: %s: %s\n", stack[0], pathError(err))
+		return 1
+	}
+	dlsh.DirStack = stack[1:]
+	dlsh.printDirs(stdout, false, false)
+	return 0
+}
+
+// popd [+N | -N], removes the top directory and changes to the next one or
+// removes the Nth directory
+func (dlsh *ExecUnit) BuiltinPopd(args []string, stdin, stdout, stderr *os.File) int {
+	args = args[1:]
+	if len(args) > 1 {
+		fmt.Fprintln(stderr, "popd: too many arguments")
+		return 1
+	}
+	if len(dlsh.DirStack) == 0 {
+		fmt.Fprintln(stderr, "popd: directory stack empty")
+		return 1
+	}
+
+	n := 0
+	if len(args) == 1 {
+		var ok bool
+		if n, ok = dlsh.dirIndex(args[0]); !ok {
+			fmt.Fprintf(stderr, "popd: %s: invalid argument\n", args[0])
+			return 1
+		}
+	}
+	if n == 0 {
+		if err := dlsh.Chdir(dlsh.DirStack[0], false); err != nil {
+			fmt.Fprintf(stderr, "popd: %s: %s\n", dlsh.DirStack[0], pathError(err))
+			return 1
+		}
+		dlsh.DirStack = dlsh.DirStack[1:]
+	} else {
+		dlsh.DirStack = slices.Delete(dlsh.DirStack, n-1, n)
+	}
+	dlsh.printDirs(stdout, false, false)
+	return 0
+}
+
+// Completes word as a path. A leading tilde prefix is expanded to read the
+// directory but kept in the candidates, directories end with `/`. A command
+// name is completed with aliases, builtins and executables in PATH.
+func (dlsh *ExecUnit) Complete(word string, command bool) []string {
+	if command && word != "" && !strings.ContainsAny(word, "/~") {
+		return dlsh.completeCommand(word)
+	}
+	dir, base := "", word
+	if i := strings.LastIndexByte(word, '/'); i >= 0 {
+		dir, base = word[:i+1], word[i+1:]
+	}
+
+	path := dir
+	if strings.HasPrefix(word, "~") {
+		prefix, rest, found := strings.Cut(word[1:], "/")
+		home, ok := dlsh.TildeDir(prefix)
+		if !ok {
+			return nil
+		}
+		if !found {
+			if isDir(home) {
+				return []string{word + "/"}
+			}
+			return nil
+		}
+		path = joinPath(home, rest[:len(rest)-len(base)])
+	}
+
+	readDir := path
+	if readDir == "" {
+		readDir = "."
+	}
+	entries, _ := os.ReadDir(readDir)
+	var candidates []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, base) || (strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".")) {
+			continue
+		}
+		if isDir(filepath.Join(readDir, name)) {
+			name += "/"
+		}
+		candidates = append(candidates, dir+name)
+	}
+	return candidates
+}
+
+func (dlsh *ExecUnit) completeCommand(prefix string) []string {
+	var names []string
+	for name := range dlsh.Aliases {
+		names = append(names, name)
+	}
+	for name := range dlsh.Funcs {
+		names = append(names, name)
+	}
+	for name := range registry {
+		names = append(names, name)
+	}
+	path, _ := dlsh.Vars.Get("PATH")
+	for _, dir := range filepath.SplitList(path) {
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			if info, err := entry.Info(); err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+				names = append(names, entry.Name())
+			}
+		}
+	}
+
+	var candidates []string
+	for _, name := range names {
+		if strings.HasPrefix(name, prefix) {
+			candidates = append(candidates, name)
+		}
+	}
+	slices.Sort(candidates)
+	return slices.Compact(candidates)
+}
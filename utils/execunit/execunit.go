@@ -1,14 +1,23 @@
 package execunit
 
 import (
+	"errors"
 	"fmt"
+	"maps"
 	"os"
+	"os/exec"
 	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 
-	"golang.org/x/sys/unix"
+	cl "dlsh/utils/cmdline"
 
-	ds "dlsh/utils/datastruct"
+	"golang.org/x/sys/unix"
+	"golang.org/x/term"
 )
 
 // Ioctl Realization: https://github.com/snabb/tcxpgrp
@@ -35,125 +44,660 @@ func IsForeground() bool {
 	return pgrp1 == pgrp2
 }
 
+// SIGTSTP is left alone, children started while it is ignored would
+// inherit that and could not be suspended
 func SigIgn() {
 	signal.Ignore(syscall.SIGTTOU)
 	signal.Ignore(syscall.SIGTTIN)
-	signal.Ignore(syscall.SIGTSTP)
 }
 
 func SigDfl() {
-	signal.Reset(syscall.SIGTSTP)
 	signal.Reset(syscall.SIGTTIN)
 	signal.Reset(syscall.SIGTTOU)
 }
 
+// The process group of a pipeline of an interactive shell, shared with the
+// subshells running its builtins and compound commands so that all of its
+// processes get the terminal and the signals sent from it
+type jobGroup struct {
+	mu   sync.Mutex
+	pgid int
+	// set when the group is given the terminal as it is made
+	tty bool
+}
+
+// Starts cmd in the group, the first process started makes it. A group
+// whose processes are all gone is made anew by the next one.
+func (g *jobGroup) start(cmd *exec.Cmd) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.pgid != 0 && unix.Kill(-g.pgid, 0) == unix.ESRCH {
+		g.pgid = 0
+	}
+	cmd.SysProcAttr.Setpgid = true
+	cmd.SysProcAttr.Pgid = g.pgid
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if g.pgid == 0 {
+		g.pgid = cmd.Process.Pid
+		if g.tty {
+			// the group made before may still have the terminal
+			SigIgn()
+			TcSetpgrp(int(os.Stdin.Fd()), g.pgid)
+			SigDfl()
+		}
+	}
+	return nil
+}
+
+// 128+n for a process killed by signal n
+func WaitStatus(ws unix.WaitStatus) int {
+	if ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return ws.ExitStatus()
+}
+
+// 127 if the command could not be found, 126 if it could not be executed
+func StartStatus(err error) int {
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, os.ErrNotExist) {
+		return 127
+	}
+	return 126
+}
+
+// Why the command name could not be started, as the shell reports it
+func startError(name string, err error) string {
+	if errors.Is(err, exec.ErrNotFound) {
+		return fmt.Sprintf("dlsh: %s: command not found", name)
+	}
+	return fmt.Sprintf("dlsh: %s: %s", name, pathError(err))
+}
+
 type ExecUnit struct {
 	Piped        bool
 	R, W         *os.File
 	Instructions Instructions
 	Err          error
 	Ins          *Instruction
-	QPid         *ds.Queue[int]
 	PGrp         int
+	Exit         bool
+	// exit status of the last pipeline, $?, and of each of its commands,
+	// PIPESTATUS
+	Status     int
+	PipeStatus []int
+	// number of command substitutions run, a command without a name takes
+	// the status of its last one
+	substs int
+	Jobs   *JobTable
+	// Bg is set while a background pipeline is started, it is neither
+	// waited for nor given the terminal
+	Bg bool
+	// JobControl is only set for an interactive shell on a terminal,
+	// subshells never touch the terminal
+	JobControl bool
+	// set for the shell reading commands from the user and its subshells,
+	// their pipelines run in process groups of their own. Scripts and -c
+	// commands leave their processes in the shell's group.
+	Interactive bool
+	// set for a copy of the shell made by Subshell
+	subshell bool
+	// set once a foreground command of an interactive shell is killed by
+	// SIGINT, the commands left are skipped. Shared with the subshells run
+	// in the foreground.
+	interrupt *atomic.Bool
+	// working directory of a subshell, the process's one belongs to the
+	// shell. Empty for the shell itself.
+	Dir string
+	// terminal modes of the shell, restored when a job stops
+	TModes   *term.State
+	Pipeline *Pipeline
+	// process group of the pipeline, led by its first process
+	JobPgid int
+	// the group of the pipeline being run in an interactive shell, made as
+	// its first process starts
+	group *jobGroup
+	// set for a subshell running part of a foreground pipeline, the
+	// processes it starts join the group of that one rather than leading
+	// groups of their own
+	outer *jobGroup
+	// standard streams commands start with, the output of a command
+	// substitution goes to a pipe
+	Stdin, Stdout, Stderr *os.File
+	Options               Options
+	// positional parameters, Args[0] is $0
+	Args []string
+	// pid of the last background job, $!
+	LastBg int
+	Vars   *Vars
+	// directories saved by pushd, below the working directory
+	DirStack []string
+	Aliases  map[string]string
+	Funcs    map[string]*FuncDef
+	// number of function calls and sourced files being run
+	FuncDepth   int
+	SourceDepth int
+	// set by `return`, the function body stops like the shell on `exit`
+	Return bool
+	// number of loops being run, and of those left by break or continue
+	LoopDepth       int
+	Break, Continue int
+	// the command history of an interactive shell, nil otherwise
+	History *cl.CliHistory
 }
 
 func NewExecUnit() *ExecUnit {
 	dlsh := new(ExecUnit)
 	dlsh.Piped = false
-	dlsh.R = os.Stdin
-	dlsh.W = os.Stdout
-	dlsh.QPid = new(ds.Queue[int])
+	dlsh.Stdin = os.Stdin
+	dlsh.Stdout = os.Stdout
+	dlsh.Stderr = os.Stderr
+	dlsh.Options = NewOptions()
+	dlsh.Args = []string{"dlsh"}
+	dlsh.Vars = NewVars()
+	dlsh.Aliases = make(map[string]string)
+	dlsh.Funcs = make(map[string]*FuncDef)
+	dlsh.Vars.Set("PWD", dlsh.Pwd())
+	dlsh.Vars.Export("PWD", true)
 	dlsh.PGrp = unix.Getpgrp()
+	dlsh.Jobs = NewJobTable()
+	dlsh.interrupt = new(atomic.Bool)
+	dlsh.TModes, _ = term.GetState(int(os.Stdin.Fd()))
 	return dlsh
 }
 
-func (dlsh *ExecUnit) ExecPipe() {
-	ins := dlsh.Ins
-	dlsh.Piped = true
-	ins.PipeRead(dlsh.R)
-	dlsh.R, dlsh.W, dlsh.Err = os.Pipe()
-	if dlsh.Err != nil {
-		fmt.Println(dlsh.Err.Error())
-		os.Exit(1)
+// A copy of the shell to run a list asynchronously, it keeps its own jobs
+// and working directory and never touches the terminal
+func (dlsh *ExecUnit) Subshell() *ExecUnit {
+	sub := NewExecUnit()
+	sub.Status = dlsh.Status
+	sub.PipeStatus = slices.Clone(dlsh.PipeStatus)
+	sub.Interactive = dlsh.Interactive
+	sub.subshell = true
+	sub.Dir = dlsh.Pwd()
+	sub.Stdin = dlsh.Stdin
+	sub.Stdout = dlsh.Stdout
+	sub.Stderr = dlsh.Stderr
+	sub.Options = dlsh.Options.Clone()
+	sub.Args = dlsh.Args
+	sub.LastBg = dlsh.LastBg
+	sub.Vars = dlsh.Vars.Clone()
+	sub.DirStack = slices.Clone(dlsh.DirStack)
+	sub.Aliases = maps.Clone(dlsh.Aliases)
+	sub.Funcs = maps.Clone(dlsh.Funcs)
+	sub.FuncDepth = dlsh.FuncDepth
+	sub.SourceDepth = dlsh.SourceDepth
+	sub.History = dlsh.History
+	return sub
+}
+
+// Hands the terminal to the process group pgrp
+func (dlsh *ExecUnit) GiveTerminal(pgrp int) {
+	if dlsh.JobControl {
+		TcSetpgrp(int(os.Stdin.Fd()), pgrp)
 	}
-	ins.PipeWrite(dlsh.W)
-	if ins.IsChdir() {
-		return
+}
+
+// Gives the terminal back to the shell, SIGTTOU is ignored meanwhile as the
+// shell is in the background when it asks for it
+func (dlsh *ExecUnit) TakeTerminal() {
+	SigIgn()
+	dlsh.GiveTerminal(dlsh.PGrp)
+	SigDfl()
+}
+
+// Set once `exit`, `return`, `break` or `continue` has been run or a
+// foreground command was interrupted, the commands left are skipped
+func (dlsh *ExecUnit) unwinding() bool {
+	return dlsh.Exit || dlsh.Return || dlsh.Break > 0 || dlsh.Continue > 0 || dlsh.interrupt.Load()
+}
+
+// Stops the commands being run as if the foreground one was interrupted
+func (dlsh *ExecUnit) Interrupt() {
+	dlsh.interrupt.Store(true)
+}
+
+// Reports whether the commands run since the last call were interrupted
+func (dlsh *ExecUnit) Interrupted() bool {
+	return dlsh.interrupt.Swap(false)
+}
+
+// Exec walks the AST and returns the exit status of the last pipeline run,
+// it stops early once `exit`, `return`, `break` or `continue` has been run.
+// The redirections of a compound command are left to RunCompound.
+func (dlsh *ExecUnit) Exec(node Node) int {
+	switch node := node.(type) {
+	case *Sequence:
+		for _, item := range node.Items {
+			dlsh.Exec(item)
+			if dlsh.unwinding() {
+				break
+			}
+		}
+	case *AndOr:
+		// Right only runs if Left succeeded for &&, or failed for ||
+		dlsh.Exec(node.Left)
+		if !dlsh.unwinding() && (dlsh.Status == 0) == (node.Op == "&&") {
+			dlsh.Exec(node.Right)
+		}
+	case *Pipeline:
+		dlsh.ExecPipeline(node)
+	case *Background:
+		dlsh.ExecBackground(node.Node)
+	case *BraceGroup:
+		dlsh.Exec(node.Body)
+	case *Subshell:
+		dlsh.execSubshell(node)
+	case *IfClause:
+		dlsh.execIf(node)
+	case *WhileClause:
+		dlsh.execWhile(node)
+	case *ForClause:
+		dlsh.execFor(node)
+	case *CaseClause:
+		dlsh.execCase(node)
+	case *FuncDef:
+		dlsh.Funcs[node.Name] = node
+		dlsh.Status = 0
 	}
-	if dlsh.Err = ins.Cmd.Start(); dlsh.Err != nil {
-		fmt.Println(dlsh.Err.Error())
-		return
+	return dlsh.Status
+}
+
+// Runs the body of a ( list ) in a subshell and waits for it. Run in the
+// foreground it keeps the terminal and the jobs of the shell, a job stopped
+// in it can be resumed from the shell. A ^C stops the shell's list too.
+func (dlsh *ExecUnit) execSubshell(node *Subshell) {
+	sub := dlsh.Subshell()
+	sub.JobControl = dlsh.JobControl
+	sub.TModes = dlsh.TModes
+	sub.Jobs = dlsh.Jobs
+	sub.interrupt = dlsh.interrupt
+	dlsh.Status = sub.Exec(node.Body)
+}
+
+// Reports whether pipeline only runs programs, its processes can then be
+// tracked directly. Builtins, functions and commands made of assignments
+// only would change the shell itself, as would a name only known once
+// expanded.
+func (dlsh *ExecUnit) isSimple(pipeline *Pipeline) bool {
+	for _, cmd := range pipeline.Cmds {
+		simple, ok := cmd.(*SimpleCommand)
+		if !ok || len(simple.Words) == 0 {
+			return false
+		}
+		raw := simple.Words[0].Raw
+		if strings.ContainsAny(raw, "$`") {
+			return false
+		}
+		name := Unquote(raw)
+		if _, ok := dlsh.Funcs[name]; ok {
+			return false
+		}
+		if _, ok := LookupBuiltin(name); ok {
+			return false
+		}
 	}
-	ins.State = true
+	return true
+}
 
-	pid := ins.Cmd.Process.Pid
-	if !IsForeground() {
-		dlsh.QPid.Enqueue(pid)
-		return
+// Runs node as a job without waiting for it. The processes of a pipeline of
+// simple commands are tracked directly, any other list runs in a subshell
+// goroutine. Only a shell with job control reports the job it started.
+func (dlsh *ExecUnit) ExecBackground(node Node) {
+	job := &Job{Cmd: node.String()}
+	if pipeline, ok := node.(*Pipeline); ok && dlsh.isSimple(pipeline) {
+		dlsh.Bg = true
+		dlsh.ExecPipeline(pipeline)
+		dlsh.Bg = false
+		job.Ins = dlsh.Instructions
+		job.Pgid = dlsh.JobPgid
+	} else {
+		sub := dlsh.Subshell()
+		job.done = make(chan struct{})
+		go func() {
+			job.Status = sub.Exec(node)
+			close(job.done)
+		}()
 	}
 
-	SigIgn()
-	TcSetpgrp(int(os.Stdin.Fd()), pid)
+	dlsh.Jobs.Add(job)
+	pids := job.Pids()
+	if len(pids) > 0 {
+		dlsh.LastBg = pids[len(pids)-1]
+	}
+	switch {
+	case !dlsh.JobControl:
+	case len(pids) > 0:
+		fmt.Fprintf(dlsh.Stdout, "[%d] %d\n", job.Id, pids[len(pids)-1])
+	default:
+		fmt.Fprintf(dlsh.Stdout, "[%d]\n", job.Id)
+	}
+	dlsh.Status = 0
 }
 
-func (dlsh *ExecUnit) DrainPipeline() {
-	for i, ins := range dlsh.Instructions {
-		if ins.State {
-			if err := ins.Cmd.Wait(); err != nil {
-				fmt.Println(err.Error())
-			}
-			ins.State = false
-			if !dlsh.QPid.Empty() {
-				TcSetpgrp(int(os.Stdin.Fd()), dlsh.QPid.Dequeue())
+// Expands the words of cmd and the targets of its redirections. Assignments
+// before a command only go to its environment, without a command they are
+// made by the shell. A compound command is expanded as it runs.
+func (dlsh *ExecUnit) Instruction(node Node) (*Instruction, error) {
+	cmd, ok := node.(*SimpleCommand)
+	if !ok {
+		ins := NewInstruction("")
+		ins.Node = node
+		ins.SetFile(0, dlsh.Stdin)
+		ins.SetFile(1, dlsh.Stdout)
+		ins.SetFile(2, dlsh.Stderr)
+		return ins, nil
+	}
+
+	substs := dlsh.substs
+	var assigns []string
+	for _, assign := range cmd.Assigns {
+		name, value, _ := strings.Cut(assign.Raw, "=")
+		value, err := dlsh.Expand(&Word{Pos: assign.Pos, Raw: value})
+		if err != nil {
+			return nil, err
+		}
+		assigns = append(assigns, name+"="+value)
+	}
+
+	var args []string
+	for _, word := range cmd.Words {
+		fields, err := dlsh.ExpandFields(word)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, fields...)
+	}
+	if len(args) == 0 {
+		args = []string{""}
+	}
+
+	var ins *Instruction
+	if fn, ok := dlsh.Funcs[args[0]]; ok {
+		ins = NewFuncInstruction(fn, args[1:]...)
+	} else {
+		ins = NewInstruction(args[0], args[1:]...)
+	}
+	ins.Assigns = assigns
+	ins.Cmd.Env = append(dlsh.Vars.Environ(), assigns...)
+	ins.Cmd.Dir = dlsh.Dir
+	if !ins.IsBuiltin() {
+		// reported by Start
+		ins.Cmd.Path, ins.Cmd.Err = dlsh.lookPath(args[0], assigns)
+	}
+	ins.SetFile(0, dlsh.Stdin)
+	ins.SetFile(1, dlsh.Stdout)
+	ins.SetFile(2, dlsh.Stderr)
+	var err error
+	if ins.Redirs, err = dlsh.expandRedirs(cmd.Redirs); err != nil {
+		return nil, err
+	}
+	if dlsh.substs != substs {
+		ins.substStatus = dlsh.Status
+	}
+	return ins, nil
+}
+
+// The program run for the command name, looked up in the directories of the
+// PATH it runs with: the one assigned before it, or else the shell's. A name
+// with a slash is run as is.
+func (dlsh *ExecUnit) lookPath(name string, assigns []string) (string, error) {
+	if strings.Contains(name, "/") {
+		return name, nil
+	}
+	path, _ := dlsh.Vars.Get("PATH")
+	for _, assign := range assigns {
+		if value, ok := strings.CutPrefix(assign, "PATH="); ok {
+			path = value
+		}
+	}
+	for _, dir := range filepath.SplitList(path) {
+		if dir == "" {
+			dir = "."
+		}
+		if file := filepath.Join(dir, name); isProgram(dlsh.Dir, file) {
+			return file, nil
+		}
+	}
+	return name, &exec.Error{Name: name, Err: exec.ErrNotFound}
+}
+
+// Reports whether file, relative to dir, is a program that can be run
+func isProgram(dir, file string) bool {
+	info, err := os.Stat(inDir(dir, file))
+	return err == nil && info.Mode().IsRegular() && info.Mode()&0111 != 0
+}
+
+func (dlsh *ExecUnit) expandRedirs(redirs []*Redirect) ([]*Redirection, error) {
+	var expanded []*Redirection
+	for _, redir := range redirs {
+		var target string
+		var err error
+		switch {
+		case redir.Doc != nil:
+			target, err = dlsh.ExpandHereDoc(redir.Doc)
+		case redir.Op == "<<<":
+			target, err = dlsh.Expand(redir.Target)
+			target += "\n"
+		default:
+			target, err = dlsh.Expand(redir.Target)
+		}
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, &Redirection{
+			Fd:     redir.Fd,
+			Op:     redir.Op,
+			Target: target,
+		})
+	}
+	return expanded, nil
+}
+
+func (dlsh *ExecUnit) ExecPipeline(pipeline *Pipeline) {
+	dlsh.Pipeline = pipeline
+	dlsh.JobPgid = 0
+	dlsh.group = dlsh.outer
+	dlsh.Err = nil
+	dlsh.Piped = false
+	dlsh.R = nil
+	dlsh.W = nil
+	dlsh.Instructions = nil
+
+	for i, cmd := range pipeline.Cmds {
+		ins, err := dlsh.Instruction(cmd)
+		if err != nil {
+			fmt.Fprintln(dlsh.Stderr, err.Error())
+			dlsh.Err = err
+			dlsh.Status = 1
+			dlsh.PipeStatus = []int{1}
+			dlsh.CloseFiles()
+			return
+		}
+		if i < len(pipeline.Cmds)-1 {
+			ins.InsType = PIPE
+		}
+		dlsh.Instructions.Append(ins)
+	}
+	// a command substitution was interrupted, the command isn't run
+	if dlsh.interrupt.Load() {
+		dlsh.Status = 130
+		dlsh.PipeStatus = []int{130}
+		dlsh.CloseFiles()
+		return
+	}
+
+	for _, ins := range dlsh.Instructions {
+		dlsh.Ins = ins
+		switch ins.InsType {
+		case EXEC:
+			if dlsh.Piped {
+				dlsh.DrainExec()
 			} else {
-				TcSetpgrp(int(os.Stdin.Fd()), dlsh.PGrp)
-				SigDfl()
+				dlsh.Run()
 			}
-		} else if !ins.IsChdir() {
-			continue
+		case PIPE:
+			dlsh.ExecPipe()
 		}
-		if i > 0 && dlsh.Instructions[i-1].InsType == PIPE {
-			ins.R.Close()
+	}
+	dlsh.CloseFiles()
+	// the status of the last command, with pipefail that of the last one
+	// to fail
+	dlsh.PipeStatus = make([]int, len(dlsh.Instructions))
+	for i, ins := range dlsh.Instructions {
+		dlsh.PipeStatus[i] = ins.Status
+	}
+	dlsh.Status = dlsh.PipeStatus[len(dlsh.PipeStatus)-1]
+	if dlsh.Options["pipefail"] {
+		for _, status := range dlsh.PipeStatus {
+			if status != 0 {
+				dlsh.Status = status
+			}
 		}
-		if ins.InsType == PIPE {
-			ins.W.Close()
+	}
+	for _, ins := range dlsh.Instructions {
+		if ins.Stopped {
+			dlsh.Status = ins.Status
 		}
 	}
+	if pipeline.Negate {
+		dlsh.Status = negate(dlsh.Status)
+	}
 }
 
-func (dlsh *ExecUnit) DrainExec() {
+func negate(status int) int {
+	if status == 0 {
+		return 1
+	}
+	return 0
+}
+
+func (dlsh *ExecUnit) CloseFiles() {
+	for _, ins := range dlsh.Instructions {
+		ins.CloseFiles()
+	}
+}
+
+// The process group of the pipeline being run, the one it is part of for a
+// subshell running a part of another pipeline
+func (dlsh *ExecUnit) jobGroup() *jobGroup {
+	if dlsh.group == nil {
+		dlsh.group = &jobGroup{tty: !dlsh.Bg && dlsh.JobControl && IsForeground()}
+	}
+	return dlsh.group
+}
+
+// Starts the current instruction in the pipeline's process group, the first
+// process started leads the group and gets the terminal. A shell that isn't
+// interactive leaves its processes in its own group. The parent's copies of
+// its pipe ends and redirected files are closed right after.
+func (dlsh *ExecUnit) Start() {
 	ins := dlsh.Ins
-	dlsh.Piped = false
-	ins.PipeRead(dlsh.R)
-	dlsh.R = os.Stdin
-	dlsh.W = os.Stdout
-	if dlsh.Err = ins.Cmd.Start(); dlsh.Err == nil {
-		ins.State = true
-		pid := ins.Cmd.Process.Pid
-		if !IsForeground() {
-			dlsh.QPid.Enqueue(pid)
+	defer ins.CloseFiles()
+	if dlsh.Err = ins.ApplyRedirects(); dlsh.Err != nil {
+		fmt.Fprintln(ins.E, dlsh.Err.Error())
+		ins.Status = 1
+		return
+	}
+	if ins.IsBuiltin() {
+		// a background builtin must not run in the shell itself
+		if len(dlsh.Instructions) > 1 || dlsh.Bg {
+			dlsh.StartBuiltin(ins)
 		} else {
-			SigIgn()
-			TcSetpgrp(int(os.Stdin.Fd()), pid)
+			ins.Status = dlsh.RunBuiltin(ins)
 		}
-		dlsh.DrainPipeline()
+		return
+	}
+	if dlsh.Interactive {
+		dlsh.Err = dlsh.jobGroup().start(ins.Cmd)
 	} else {
-		fmt.Println(dlsh.Err.Error())
+		dlsh.Err = ins.Cmd.Start()
+	}
+	if dlsh.Err != nil {
+		fmt.Fprintln(ins.E, startError(ins.Name(), dlsh.Err))
+		ins.Status = StartStatus(dlsh.Err)
+		return
+	}
+	ins.State = true
+
+	if dlsh.Interactive && dlsh.outer == nil {
+		dlsh.JobPgid = ins.Cmd.SysProcAttr.Pgid
+		if dlsh.JobPgid == 0 {
+			dlsh.JobPgid = ins.Cmd.Process.Pid
+		}
 	}
 }
 
-func (dlsh *ExecUnit) Run() {
+func (dlsh *ExecUnit) ExecPipe() {
 	ins := dlsh.Ins
-	if err := ins.Cmd.Start(); err != nil {
-		fmt.Println(err.Error())
+	dlsh.Piped = true
+	ins.PipeRead(dlsh.R)
+	dlsh.R, dlsh.W, dlsh.Err = os.Pipe()
+	if dlsh.Err != nil {
+		fmt.Fprintln(dlsh.Stderr, dlsh.Err.Error())
+		os.Exit(1)
+	}
+	ins.PipeWrite(dlsh.W)
+	dlsh.Start()
+}
+
+// Waits for every process of the pipeline, or for its group to stop, then
+// takes the terminal back. Builtins running in goroutines are waited for
+// last. As in a shell that got the ^C itself, a process killed by SIGINT
+// stops the commands of an interactive shell left to run, those of the
+// goroutines too.
+func (dlsh *ExecUnit) DrainPipeline() {
+	if dlsh.Bg {
 		return
 	}
+	started := func(ins *Instruction) bool { return ins.State }
+	if slices.ContainsFunc(dlsh.Instructions, started) {
+		// the builtins running in goroutines set their own status
+		procs := slices.DeleteFunc(slices.Clone(dlsh.Instructions), func(ins *Instruction) bool {
+			return ins.done != nil
+		})
+		job := &Job{Cmd: dlsh.Pipeline.String(), Pgid: dlsh.JobPgid, Ins: procs}
+		job.Update(true)
+		for job.State == Stopped && dlsh.outer != nil {
+			// stopped along with the pipeline it is part of, it goes on
+			// once that one is resumed
+			job.reap(0)
+			job.Update(true)
+		}
+		if job.State == Stopped {
+			dlsh.Suspend(job)
+			return
+		}
+		if dlsh.Interactive && slices.ContainsFunc(dlsh.Instructions, interrupted) {
+			dlsh.Interrupt()
+		}
+	}
+	for _, ins := range dlsh.Instructions {
+		if ins.done != nil {
+			<-ins.done
+		}
+	}
+	if dlsh.outer == nil && dlsh.group != nil && dlsh.group.tty {
+		dlsh.TakeTerminal()
+	}
+}
 
-	pid := ins.Cmd.Process.Pid
+func interrupted(ins *Instruction) bool {
+	return ins.Signaled && ins.Status == 128+int(syscall.SIGINT)
+}
 
-	SigIgn()
-	TcSetpgrp(int(os.Stdin.Fd()), pid)
-	ins.Cmd.Wait()
-	TcSetpgrp(int(os.Stdin.Fd()), dlsh.PGrp)
-	SigDfl()
+func (dlsh *ExecUnit) DrainExec() {
+	ins := dlsh.Ins
+	dlsh.Piped = false
+	ins.PipeRead(dlsh.R)
+	dlsh.R = nil
+	dlsh.W = nil
+	dlsh.Start()
+	dlsh.DrainPipeline()
+}
+
+func (dlsh *ExecUnit) Run() {
+	dlsh.Start()
+	dlsh.DrainPipeline()
 }
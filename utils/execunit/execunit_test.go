@@ -0,0 +1,163 @@
+package execunit
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A new shell started in a directory of its own, it leaves the environment
+// of the test alone
+func newShell(t *testing.T) *ExecUnit {
+	t.Chdir(t.TempDir())
+	dlsh := NewExecUnit()
+	dlsh.Vars.sync = false
+	dlsh.Vars.Set("PWD", dlsh.Pwd())
+	return dlsh
+}
+
+// Runs src as a script with pipes for the standard streams, returns what
+// it wrote to them and its status
+func runShell(t *testing.T, dlsh *ExecUnit, src string) (stdout, stderr string, status int) {
+	t.Helper()
+	var err error
+	if dlsh.Stdin, err = os.Open(os.DevNull); err != nil {
+		t.Fatal(err)
+	}
+	defer dlsh.Stdin.Close()
+	read := func(w **os.File) <-chan string {
+		r, pw, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		*w = pw
+		out := make(chan string)
+		go func() {
+			data, _ := io.ReadAll(r)
+			r.Close()
+			out <- string(data)
+		}()
+		return out
+	}
+	outc, errc := read(&dlsh.Stdout), read(&dlsh.Stderr)
+
+	dlsh.RunScript(strings.NewReader(src), "dlsh")
+	dlsh.Stdout.Close()
+	dlsh.Stderr.Close()
+	return <-outc, <-errc, dlsh.Status
+}
+
+type shellTest struct {
+	src            string
+	stdout, stderr string
+	status         int
+}
+
+func testShell(t *testing.T, tests []shellTest) {
+	t.Helper()
+	for _, test := range tests {
+		stdout, stderr, status := runShell(t, newShell(t), test.src)
+		if stdout != test.stdout || stderr != test.stderr || status != test.status {
+			t.Errorf("%q: got %q, %q, %d, want %q, %q, %d", test.src,
+				stdout, stderr, status, test.stdout, test.stderr, test.status)
+		}
+	}
+}
+
+// Runs the tests in an interactive shell without a terminal, a line that
+// was interrupted has status 130
+func testInteractive(t *testing.T, tests []shellTest) {
+	t.Helper()
+	for _, test := range tests {
+		dlsh := newShell(t)
+		dlsh.Interactive = true
+		stdout, stderr, _ := runShell(t, dlsh, test.src)
+		status := dlsh.Status
+		if dlsh.Interrupted() {
+			status = 130
+		}
+		if stdout != test.stdout || stderr != test.stderr || status != test.status {
+			t.Errorf("%q: got %q, %q, %d, want %q, %q, %d", test.src,
+				stdout, stderr, status, test.stdout, test.stderr, test.status)
+		}
+	}
+}
+
+func TestLookPath(t *testing.T) {
+	bin := t.TempDir()
+	hello := filepath.Join(bin, "hello")
+	if err := os.WriteFile(hello, []byte("#!/bin/sh\necho hello $1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	testShell(t, []shellTest{
+		{"PATH=" + bin + " hello a", "hello a\n", "", 0},
+		{"PATH=" + bin + " hello a; hello b", "hello a\n", "dlsh: hello: command not found\n", 127},
+		{"PATH=" + bin + "; hello a; ls", "hello a\n", "dlsh: ls: command not found\n", 127},
+		{"PATH=/nonexistent:" + bin + " hello a", "hello a\n", "", 0},
+		{"PATH=" + bin + "; export -n PATH; hello a", "hello a\n", "", 0},
+		{"PATH=/nonexistent " + hello + " a", "hello a\n", "", 0},
+		{"cd " + bin + "; PATH=: hello a", "hello a\n", "", 0},
+		{"nosuch a b", "", "dlsh: nosuch: command not found\n", 127},
+		{"/nonexistent/nosuch", "", "dlsh: /nonexistent/nosuch: no such file or directory\n", 127},
+		{"/", "", "dlsh: /: permission denied\n", 126},
+		{"PATH=" + bin + "; type -t hello", "file\n", "", 0},
+	})
+}
+
+// An interactive shell stops the commands left once one is killed by
+// SIGINT, as if it got the ^C itself
+func TestInterrupted(t *testing.T) {
+	kill := "sh -c 'kill -INT $$'"
+	tests := []shellTest{
+		{kill + "; echo no", "", "", 130},
+		{"echo a; " + kill + " && echo no || echo no; echo no", "a\n", "", 130},
+		{"while true; do " + kill + "; done; echo no", "", "", 130},
+		{"for i in 1 2; do echo $i; " + kill + "; done; echo no", "1\n", "", 130},
+		{"(echo a; " + kill + "; echo no); echo no", "a\n", "", 130},
+		{"f() { " + kill + "; echo no; }; f; echo no", "", "", 130},
+		{": | " + kill + "; echo no", "", "", 130},
+		{"sh -c 'kill -TERM $$'; echo a", "a\n", "", 0},
+	}
+	testInteractive(t, tests)
+
+	// a script goes on, a ^C from the terminal would kill it too
+	testShell(t, []shellTest{
+		{kill + "; echo a", "a\n", "", 0},
+	})
+}
+
+// The processes started by the builtins and compound commands of a pipeline
+// are in its group, and stop once it is interrupted
+func TestPipelineGroup(t *testing.T) {
+	pgrp := `sh -c 'cut -d" " -f5 /proc/$$/stat'`
+	kill := "sh -c 'kill -INT $$'"
+	testInteractive(t, []shellTest{
+		{"{ " + pgrp + "; } | { " + pgrp + "; cat; } | { cat; " + pgrp + "; } | uniq | wc -l", "1\n", "", 0},
+		{"for i in 1 2; do " + pgrp + "; done | { cat; " + pgrp + "; } | uniq | wc -l", "1\n", "", 0},
+		{"{ sleep 0.1; " + pgrp + "; } | : ; echo a", "a\n", "", 0},
+		{"while true; do :; done | " + kill + "; echo no", "", "", 130},
+		{"while true; do " + kill + "; done | cat; echo no", "", "", 130},
+	})
+}
+
+func TestCommandSubst(t *testing.T) {
+	testShell(t, []shellTest{
+		{"echo $(echo a; echo b)", "a b\n", "", 0},
+		{"echo \"$(printf 'a\\n\\n\\n')\"x", "ax\n", "", 0},
+		{"echo `echo a` $(echo $(echo b))", "a b\n", "", 0},
+		{"echo `echo \\`echo a\\``", "a\n", "", 0},
+		{"x=$(exit 3); echo $?", "3\n", "", 0},
+		{"x=$(exit 3) y=$(true); echo $?", "0\n", "", 0},
+		{"x=$(false); echo $x$?", "1\n", "", 0},
+		{"cd /tmp; echo $(cd /; pwd) $(pwd) $PWD", "/ /tmp /tmp\n", "", 0},
+		{"echo $(echo a >&2)", "\n", "a\n", 0},
+		{"echo $(ls /nonexistent 2>/dev/null)x", "x\n", "", 0},
+	})
+	testInteractive(t, []shellTest{
+		{"echo a$(sh -c 'kill -INT $$')b; echo no", "", "", 130},
+		{"x=$(sh -c 'kill -INT $$'); echo no", "", "", 130},
+		{"for i in 1 2; do echo $i `sh -c 'kill -INT $$'`; done", "", "", 130},
+	})
+}
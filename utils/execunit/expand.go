@@ -0,0 +1,384 @@
+package execunit
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const defaultIFS = " \t\n"
+
+type expander struct {
+	dlsh *ExecUnit
+	src  string
+	i    int
+	sb   strings.Builder
+	// split unquoted expansions into fields, unset for redirection targets
+	// and here-documents
+	split bool
+	// inside double quotes
+	quoted bool
+	// the current field holds something, possibly just an empty ""
+	started bool
+	fields  []string
+	// the current field as a glob pattern, quoted text escaped
+	pat  strings.Builder
+	glob bool
+	err  error
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNameChar(c byte) bool {
+	return isNameStart(c) || (c >= '0' && c <= '9')
+}
+
+func (dlsh *ExecUnit) newExpander(src string, split bool) *expander {
+	exp := new(expander)
+	exp.dlsh = dlsh
+	exp.src = src
+	exp.split = split
+	return exp
+}
+
+// ExpandFields performs tilde, parameter and command substitution on a raw
+// word, splits the unquoted results into fields, expands the fields holding
+// glob patterns to the matching paths and removes the quotes. Single quoted
+// text is never expanded.
+func (dlsh *ExecUnit) ExpandFields(w *Word) ([]string, error) {
+	exp := dlsh.newExpander(w.Raw, true)
+	exp.word()
+	return exp.fields, exp.err
+}
+
+// Expand is ExpandFields without field splitting, the result is always a
+// single string
+func (dlsh *ExecUnit) Expand(w *Word) (string, error) {
+	exp := dlsh.newExpander(w.Raw, false)
+	exp.word()
+	return exp.fields[0], exp.err
+}
+
+// Expands parameters and commands in the body of a here-document unless its
+// delimiter was quoted. A backslash only escapes $ ` \ and newline.
+func (dlsh *ExecUnit) ExpandHereDoc(doc *HereDoc) (string, error) {
+	if doc.Quoted {
+		return doc.Body, nil
+	}
+	exp := dlsh.newExpander(doc.Body, false)
+	exp.quoted = true
+	for exp.i < len(exp.src) {
+		switch c := exp.src[exp.i]; c {
+		case '\\':
+			exp.escape("$`\\\n")
+		case '$':
+			exp.dollar()
+		case '`':
+			exp.backquoted()
+		default:
+			exp.write(exp.src[exp.i : exp.i+1])
+			exp.i++
+		}
+	}
+	return exp.sb.String(), exp.err
+}
+
+// Removes quotes and backslashes from a word without expanding it
+func Unquote(raw string) string {
+	var sb strings.Builder
+	var quote byte
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		switch {
+		case c == quote:
+			quote = 0
+		case quote == '\'':
+			sb.WriteByte(c)
+		case c == '\\' && i+1 < len(raw) && (quote == 0 || strings.IndexByte("$`\"\\", raw[i+1]) != -1):
+			i++
+			sb.WriteByte(raw[i])
+		case quote == 0 && (c == '\'' || c == '"'):
+			quote = c
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+func (exp *expander) word() {
+	exp.tilde()
+	exp.text()
+	if exp.started || !exp.split {
+		exp.endField()
+	}
+}
+
+// Expands the rest of src, quote removal included. Inside double quotes
+// single quotes are kept.
+func (exp *expander) text() {
+	for exp.i < len(exp.src) {
+		switch c := exp.src[exp.i]; {
+		case c == '\\' && exp.quoted:
+			exp.escape("$`\"\\\n")
+		case c == '\\':
+			exp.i++
+			if exp.i < len(exp.src) && exp.src[exp.i] != '\n' {
+				exp.literal(exp.src[exp.i : exp.i+1])
+			}
+			exp.i++
+		case c == '\'' && !exp.quoted:
+			end := strings.IndexByte(exp.src[exp.i+1:], '\'')
+			if end < 0 {
+				end = len(exp.src) - exp.i - 1
+			}
+			exp.started = true
+			exp.literal(exp.src[exp.i+1 : exp.i+1+end])
+			exp.i += end + 2
+		case c == '"':
+			exp.i++
+			exp.started = true
+			quoted := exp.quoted
+			exp.quoted = true
+			exp.doubleQuoted()
+			exp.quoted = quoted
+		case c == '$':
+			exp.dollar()
+		case c == '`':
+			exp.backquoted()
+		default:
+			exp.write(exp.src[exp.i : exp.i+1])
+			exp.i++
+		}
+	}
+}
+
+// Expands text in the middle of the current word, as the operand of a
+// parameter expansion
+func (exp *expander) sub(text string) {
+	src, i := exp.src, exp.i
+	exp.src, exp.i = text, 0
+	exp.text()
+	exp.src, exp.i = src, i
+}
+
+// Writes text to the current field, unless it is quoted its pattern
+// characters are active
+func (exp *expander) write(s string) {
+	if exp.quoted {
+		exp.literal(s)
+		return
+	}
+	if len(s) > 0 {
+		exp.started = true
+	}
+	exp.sb.WriteString(s)
+	exp.pat.WriteString(s)
+	exp.glob = exp.glob || strings.ContainsAny(s, "*?[")
+}
+
+// Writes text that only matches itself
+func (exp *expander) literal(s string) {
+	if len(s) > 0 {
+		exp.started = true
+	}
+	exp.sb.WriteString(s)
+	exp.pat.WriteString(QuoteGlob(s))
+}
+
+// Ends the current field, a pattern is replaced by the paths it matches.
+// Without a match it is kept as is unless nullglob or failglob are set.
+func (exp *expander) endField() {
+	field, pat := exp.sb.String(), exp.pat.String()
+	exp.sb.Reset()
+	exp.pat.Reset()
+	exp.started = false
+	glob := exp.glob
+	exp.glob = false
+	if !exp.split || !glob || !HasGlob(pat) {
+		exp.fields = append(exp.fields, field)
+		return
+	}
+
+	matches := exp.dlsh.Glob(pat)
+	switch {
+	case len(matches) > 0:
+		exp.fields = append(exp.fields, matches...)
+	case exp.dlsh.Options["failglob"]:
+		if exp.err == nil {
+			exp.err = fmt.Errorf("dlsh: no match: %s", field)
+		}
+	case !exp.dlsh.Options["nullglob"]:
+		exp.fields = append(exp.fields, field)
+	}
+}
+
+// Writes the result of an expansion. Unless it is quoted it is split on the
+// characters of IFS: IFS whitespace separates fields and collapses, any other
+// IFS character always ends a field.
+func (exp *expander) expansion(s string) {
+	if !exp.split || exp.quoted {
+		exp.write(s)
+		return
+	}
+
+	ifs, ok := exp.dlsh.Vars.Get("IFS")
+	if !ok {
+		ifs = defaultIFS
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case strings.IndexByte(ifs, c) == -1:
+			exp.write(s[i : i+1])
+		case strings.IndexByte(defaultIFS, c) == -1, exp.started:
+			exp.endField()
+		}
+	}
+}
+
+// A tilde prefix, up to the first `/`, is expanded if none of it is quoted
+func (exp *expander) tilde() {
+	if !strings.HasPrefix(exp.src, "~") {
+		return
+	}
+	prefix, _, _ := strings.Cut(exp.src[1:], "/")
+	if strings.ContainsAny(prefix, "'\"\\$`") {
+		return
+	}
+	if dir, ok := exp.dlsh.TildeDir(prefix); ok {
+		exp.literal(dir)
+		exp.i += 1 + len(prefix)
+	}
+}
+
+// A backslash is removed if it escapes one of chars and kept otherwise, an
+// escaped newline is removed altogether
+func (exp *expander) escape(chars string) {
+	if exp.i+1 < len(exp.src) && strings.IndexByte(chars, exp.src[exp.i+1]) != -1 {
+		exp.i++
+		if exp.src[exp.i] != '\n' {
+			exp.write(exp.src[exp.i : exp.i+1])
+		}
+	} else {
+		exp.write(`\`)
+	}
+	exp.i++
+}
+
+// Inside double quotes a backslash only escapes $ ` " \ and newline
+func (exp *expander) doubleQuoted() {
+	for exp.i < len(exp.src) {
+		switch c := exp.src[exp.i]; c {
+		case '"':
+			exp.i++
+			return
+		case '\\':
+			exp.escape("$`\"\\\n")
+		case '$':
+			exp.dollar()
+		case '`':
+			exp.backquoted()
+		default:
+			exp.write(exp.src[exp.i : exp.i+1])
+			exp.i++
+		}
+	}
+}
+
+// $(cmd), $NAME, ${...} or a special parameter, a lone `$` is kept as is
+func (exp *expander) dollar() {
+	src := exp.src
+	start := exp.i + 1
+	if start < len(src) && src[start] == '(' {
+		lex := NewLexer(src)
+		lex.aliases = exp.dlsh.Aliases
+		lex.pos.Offset = start - 1
+		if seq, err := lex.subst(); err == nil {
+			exp.expansion(exp.dlsh.CommandSubst(seq))
+			exp.i = lex.pos.Offset
+			return
+		}
+	}
+
+	if start < len(src) && src[start] == '{' {
+		if end := braceEnd(src, start); end > 0 {
+			exp.i = end + 1
+			exp.braced(src[start+1 : end])
+			return
+		}
+	}
+
+	end := start
+	switch {
+	case end == len(src):
+	case isNameStart(src[end]):
+		for end < len(src) && isNameChar(src[end]) {
+			end++
+		}
+	case strings.IndexByte(specialParams, src[end]) != -1:
+		end++
+	}
+	if end == start {
+		exp.write("$")
+		exp.i++
+		return
+	}
+	exp.i = end
+	exp.param(src[start:end])
+}
+
+// `cmd`, a backslash inside only escapes $ ` and \
+func (exp *expander) backquoted() {
+	var body strings.Builder
+	i := exp.i + 1
+	for ; i < len(exp.src) && exp.src[i] != '`'; i++ {
+		if exp.src[i] == '\\' && i+1 < len(exp.src) && strings.IndexByte("$`\\", exp.src[i+1]) != -1 {
+			i++
+		}
+		body.WriteByte(exp.src[i])
+	}
+	exp.i = i + 1
+
+	seq, err := exp.dlsh.Parse(body.String())
+	if err != nil {
+		fmt.Fprintln(exp.dlsh.Stderr, err.Error())
+		return
+	}
+	exp.expansion(exp.dlsh.CommandSubst(seq))
+}
+
+// Runs seq in a subshell and returns its output without the trailing
+// newlines. Its processes are in the foreground with the command it is
+// expanded for, a ^C stops that command too.
+func (dlsh *ExecUnit) CommandSubst(seq *Sequence) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		fmt.Fprintln(dlsh.Stderr, err.Error())
+		return ""
+	}
+	output := make(chan []byte)
+	go func() {
+		out, _ := io.ReadAll(r)
+		r.Close()
+		output <- out
+	}()
+
+	sub := dlsh.Subshell()
+	sub.Stdout = w
+	sub.interrupt = dlsh.interrupt
+	switch {
+	case dlsh.outer != nil:
+		sub.outer = dlsh.outer
+	case dlsh.JobControl:
+		// the shell's own group, it has the terminal
+		sub.outer = &jobGroup{pgid: dlsh.PGrp}
+	}
+	dlsh.Status = sub.Exec(seq)
+	dlsh.substs++
+	w.Close()
+	return strings.TrimRight(string(<-output), "\n")
+}
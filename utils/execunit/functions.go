@@ -0,0 +1,102 @@
+package execunit
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Calls nested deeper than this fail instead of exhausting the stack
+const maxFuncDepth = 1000
+
+// Runs fn with args as its positional parameters, $0 is left alone.
+// Assignments before the call are exported to it for its duration only.
+func (dlsh *ExecUnit) CallFunc(fn *FuncDef, args, assigns []string, stdin, stdout, stderr *os.File) int {
+	if dlsh.FuncDepth >= maxFuncDepth {
+		fmt.Fprintf(stderr, "dlsh: %s: maximum function nesting level exceeded (%d)\n", fn.Name, maxFuncDepth)
+		return 1
+	}
+	saved, loops := dlsh.Args, dlsh.LoopDepth
+	dlsh.Args = append([]string{saved[0]}, args[1:]...)
+	dlsh.LoopDepth = 0
+	dlsh.FuncDepth++
+	dlsh.Vars.PushScope()
+	status := 0
+	for _, assign := range assigns {
+		name, value, _ := strings.Cut(assign, "=")
+		if err := dlsh.Vars.Local(name); err != nil {
+			fmt.Fprintln(stderr, err.Error())
+			status = 1
+			break
+		}
+		dlsh.Vars.Set(name, value)
+		dlsh.Vars.Export(name, true)
+	}
+	if status == 0 {
+		status = dlsh.RunCompound(fn.Body, stdin, stdout, stderr)
+	}
+	dlsh.Vars.PopScope()
+	dlsh.FuncDepth--
+	dlsh.Args, dlsh.LoopDepth = saved, loops
+	dlsh.Return = false
+	return status
+}
+
+// return [n]
+func (dlsh *ExecUnit) BuiltinReturn(args []string, stdin, stdout, stderr *os.File) int {
+	if dlsh.FuncDepth == 0 && dlsh.SourceDepth == 0 {
+		fmt.Fprintln(stderr, "return: can only `return' from a function or sourced script")
+		return 1
+	}
+	status := dlsh.Status
+	if len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			fmt.Fprintf(stderr, "return: %s: numeric argument required\n", args[1])
+			n = 2
+		}
+		status = n & 0xff
+	}
+	dlsh.Return = true
+	return status
+}
+
+// type [-t] name ...
+func (dlsh *ExecUnit) BuiltinType(args []string, stdin, stdout, stderr *os.File) int {
+	args = args[1:]
+	short := false
+	if len(args) > 0 && args[0] == "-t" {
+		short = true
+		args = args[1:]
+	}
+	status := 0
+	for _, name := range args {
+		kind := dlsh.CommandType(name)
+		if kind == "" {
+			if !short {
+				fmt.Fprintf(stderr, "type: %s: not found\n", name)
+			}
+			status = 1
+			continue
+		}
+		if short {
+			fmt.Fprintln(stdout, kind)
+			continue
+		}
+		switch kind {
+		case "alias":
+			fmt.Fprintf(stdout, "%s is aliased to `%s'\n", name, dlsh.Aliases[name])
+		case "keyword":
+			fmt.Fprintf(stdout, "%s is a shell keyword\n", name)
+		case "function":
+			fmt.Fprintf(stdout, "%s is a function\n%s\n", name, Format(dlsh.Funcs[name]))
+		case "builtin":
+			fmt.Fprintf(stdout, "%s is a shell builtin\n", name)
+		case "file":
+			path, _ := dlsh.lookPath(name, nil)
+			fmt.Fprintf(stdout, "%s is %s\n", name, path)
+		}
+	}
+	return status
+}
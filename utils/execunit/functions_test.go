@@ -0,0 +1,33 @@
+package execunit
+
+import "testing"
+
+func TestFunctions(t *testing.T) {
+	testShell(t, []shellTest{
+		{"f() { echo $# $1 $2; }; f a 'b c'", "2 a b c\n", "", 0},
+		{"f() { echo $@; }; set -- x y; f a; echo $@", "a\nx y\n", "", 0},
+		{"f()\n{\n  echo a\n}\nf", "a\n", "", 0},
+		{"f() { return 3; echo no; }; f; echo $?", "3\n", "", 0},
+		{"f() { false; return; }; f; echo $?", "1\n", "", 0},
+		{"f() { for i in 1 2; do return $i; done; }; f; echo $?", "1\n", "", 0},
+		{"return", "", "return: can only `return' from a function or sourced script\n", 1},
+		{"f() { return x; }; f", "", "return: x: numeric argument required\n", 2},
+		{"x=1; f() { local x=2; echo $x; }; f; echo $x", "2\n1\n", "", 0},
+		{"x=1; f() { local x; x=2; }; f; echo $x", "1\n", "", 0},
+		{"f() { x=2; }; x=1; f; echo $x", "2\n", "", 0},
+		{"g() { echo $x; }; f() { local x=2; g; }; x=1; f", "2\n", "", 0},
+		{"local x", "", "dlsh: local: can only be used in a function\n", 1},
+		{"f() { echo $x; sh -c 'echo $x'; }; x=1 f; echo $x", "1\n1\n\n", "", 0},
+		{"f() { echo a; }; f() { echo b; }; f", "b\n", "", 0},
+		{"f() { echo a; }; unset -f f; f", "", "dlsh: f: command not found\n", 127},
+		{"f() { echo a; }; type -t f", "function\n", "", 0},
+		{"f() { echo $1; if [ $1 != aaa ]; then f ${1}a; fi; }; f a", "a\naa\naaa\n", "", 0},
+		{"f() { f; }; f", "", "dlsh: f: maximum function nesting level exceeded (1000)\n", 1},
+		{"f() { echo a; } >f; f; cat f", "a\n", "", 0},
+		{"f() { cat; }; echo a | f", "a\n", "", 0},
+		{"f() { echo a; }; f | cat", "a\n", "", 0},
+		{"f() { echo $1; }; f a & wait; f b", "a\nb\n", "", 0},
+		{"f() { x=2; }; x=1; f & wait; echo $x", "1\n", "", 0},
+		{"f() { echo a; }; type f", "f is a function\nf () \n{\n    echo a\n}\n", "", 0},
+	})
+}
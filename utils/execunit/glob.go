@@ -0,0 +1,268 @@
+package execunit
+
+import (
+	"os"
+	"slices"
+	"strings"
+)
+
+// Escapes the pattern characters of s so that it only matches itself
+func QuoteGlob(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(`*?[]\`, s[i]) != -1 {
+			sb.WriteByte('\\')
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+// Reports whether pat holds an unescaped * ? or [
+func HasGlob(pat string) bool {
+	for i := 0; i < len(pat); i++ {
+		switch pat[i] {
+		case '\\':
+			i++
+		case '*', '?', '[':
+			return true
+		}
+	}
+	return false
+}
+
+// Removes the backslashes escaping pattern characters
+func unescapeGlob(pat string) string {
+	if !strings.Contains(pat, `\`) {
+		return pat
+	}
+	var sb strings.Builder
+	for i := 0; i < len(pat); i++ {
+		if pat[i] == '\\' && i+1 < len(pat) {
+			i++
+		}
+		sb.WriteByte(pat[i])
+	}
+	return sb.String()
+}
+
+// Match reports whether the whole of name matches the shell pattern pat.
+// `*` matches any string, `?` any character and [...] any character of the
+// bracket expression, negated by a leading ! or ^. A backslash makes the
+// next character literal. Unlike pathname expansion `/` isn't special.
+func Match(pat, name string) bool {
+	p, s := []rune(pat), []rune(name)
+	pi, si := 0, 0
+	// where to resume after the last `*` if the rest fails to match
+	star, next := -1, 0
+	for si < len(s) {
+		if pi < len(p) {
+			switch p[pi] {
+			case '*':
+				star, next = pi, si
+				pi++
+				continue
+			case '?':
+				pi++
+				si++
+				continue
+			case '[':
+				if ok, end, valid := matchBracket(p, pi, s[si]); valid {
+					if ok {
+						pi = end
+						si++
+						continue
+					}
+				} else if s[si] == '[' {
+					pi++
+					si++
+					continue
+				}
+			case '\\':
+				if pi+1 == len(p) && s[si] == '\\' {
+					pi++
+					si++
+					continue
+				}
+				if pi+1 < len(p) && p[pi+1] == s[si] {
+					pi += 2
+					si++
+					continue
+				}
+			default:
+				if p[pi] == s[si] {
+					pi++
+					si++
+					continue
+				}
+			}
+		}
+		if star < 0 {
+			return false
+		}
+		next++
+		pi, si = star+1, next
+	}
+	for pi < len(p) && p[pi] == '*' {
+		pi++
+	}
+	return pi == len(p)
+}
+
+// Matches c against the bracket expression starting at p[i], end is the
+// index just past its `]`. A `[` without a closing `]` is not valid and is
+// taken literally.
+func matchBracket(p []rune, i int, c rune) (ok bool, end int, valid bool) {
+	i++
+	negate := i < len(p) && (p[i] == '!' || p[i] == '^')
+	if negate {
+		i++
+	}
+	for first := true; i < len(p); first = false {
+		if p[i] == ']' && !first {
+			return ok != negate, i + 1, true
+		}
+		lo := p[i]
+		if lo == '\\' && i+1 < len(p) {
+			i++
+			lo = p[i]
+		}
+		i++
+		hi := lo
+		if i+1 < len(p) && p[i] == '-' && p[i+1] != ']' {
+			hi = p[i+1]
+			if hi == '\\' && i+2 < len(p) {
+				i++
+				hi = p[i+1]
+			}
+			i += 2
+		}
+		if lo <= c && c <= hi {
+			ok = true
+		}
+	}
+	return false, 0, false
+}
+
+type globber struct {
+	dotglob  bool
+	globstar bool
+	// directory relative patterns are matched in, empty for the process's
+	// working directory
+	wd string
+}
+
+// Glob returns the sorted paths matching pat. Each path component is matched
+// on its own, a leading `.` has to be matched explicitly unless dotglob is
+// set and with globstar a `**` component matches any number of directories.
+func (dlsh *ExecUnit) Glob(pat string) []string {
+	g := &globber{dotglob: dlsh.Options["dotglob"], globstar: dlsh.Options["globstar"], wd: dlsh.Dir}
+	dir := ""
+	if strings.HasPrefix(pat, "/") {
+		dir = "/"
+		pat = strings.TrimLeft(pat, "/")
+	}
+	matches := g.glob(dir, strings.Split(pat, "/"))
+	slices.Sort(matches)
+	return slices.Compact(matches)
+}
+
+func joinPath(dir, name string) string {
+	if dir == "" {
+		return name
+	}
+	if strings.HasSuffix(dir, "/") {
+		return dir + name
+	}
+	return dir + "/" + name
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+func (g *globber) isDir(path string) bool {
+	return isDir(inDir(g.wd, path))
+}
+
+func (g *globber) exists(path string) bool {
+	_, err := os.Lstat(inDir(g.wd, path))
+	return err == nil
+}
+
+func (g *globber) readDir(dir string) []os.DirEntry {
+	if dir == "" {
+		dir = "."
+	}
+	entries, _ := os.ReadDir(inDir(g.wd, dir))
+	return entries
+}
+
+func (g *globber) hidden(name, pat string) bool {
+	return strings.HasPrefix(name, ".") && !g.dotglob && !strings.HasPrefix(pat, ".")
+}
+
+func (g *globber) glob(dir string, parts []string) []string {
+	if len(parts) == 0 {
+		return []string{dir}
+	}
+	pat, rest := parts[0], parts[1:]
+
+	// a trailing slash only matches directories
+	if pat == "" && len(rest) == 0 {
+		if dir != "" && g.isDir(dir) {
+			return []string{joinPath(dir, "")}
+		}
+		return nil
+	}
+
+	if !HasGlob(pat) {
+		path := joinPath(dir, unescapeGlob(pat))
+		if len(rest) == 0 && !g.exists(path) {
+			return nil
+		}
+		return g.glob(path, rest)
+	}
+
+	if pat == "**" && g.globstar {
+		return g.anyDirs(dir, rest)
+	}
+
+	var matches []string
+	for _, entry := range g.readDir(dir) {
+		name := entry.Name()
+		if g.hidden(name, pat) || !Match(pat, name) {
+			continue
+		}
+		path := joinPath(dir, name)
+		if len(rest) > 0 && !g.isDir(path) {
+			continue
+		}
+		matches = append(matches, g.glob(path, rest)...)
+	}
+	return matches
+}
+
+// `**` matches dir itself and every directory below it, symbolic links
+// aren't followed. As the last component it matches every file as well.
+func (g *globber) anyDirs(dir string, rest []string) []string {
+	var matches []string
+	if len(rest) > 0 {
+		matches = g.glob(dir, rest)
+	}
+	for _, entry := range g.readDir(dir) {
+		name := entry.Name()
+		if g.hidden(name, "") {
+			continue
+		}
+		path := joinPath(dir, name)
+		if len(rest) == 0 {
+			matches = append(matches, path)
+		}
+		if entry.IsDir() {
+			matches = append(matches, g.anyDirs(path, rest)...)
+		}
+	}
+	return matches
+}
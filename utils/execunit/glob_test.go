@@ -0,0 +1,117 @@
+package execunit
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pat, name string
+		want      bool
+	}{
+		{"", "", true},
+		{"abc", "abc", true},
+		{"abc", "abd", false},
+		{"*", "", true},
+		{"*", "anything/at/all", true},
+		{"a*", "abc", true},
+		{"*c", "abc", true},
+		{"a*c", "ac", true},
+		{"a*c", "abcd", false},
+		{"*a*b*", "xaybz", true},
+		{"?", "é", true},
+		{"??", "a", false},
+		{"[abc]", "b", true},
+		{"[abc]", "d", false},
+		{"[a-c]x", "bx", true},
+		{"[!a-c]", "b", false},
+		{"[^a-c]", "d", true},
+		{"[]]", "]", true},
+		{"[a-]", "-", true},
+		{"[", "[", true},
+		{"[ab", "[ab", true},
+		{`\*`, "*", true},
+		{`\*`, "a", false},
+		{`a\`, `a\`, true},
+		{`[\]]`, "]", true},
+		{"*.go", "main.go", true},
+		{"*.go", "main.go.orig", false},
+	}
+	for _, test := range tests {
+		if got := Match(test.pat, test.name); got != test.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", test.pat, test.name, got, test.want)
+		}
+	}
+}
+
+func TestHasGlob(t *testing.T) {
+	tests := []struct {
+		pat  string
+		want bool
+	}{
+		{"abc", false},
+		{"a*", true},
+		{"a?", true},
+		{"[a]", true},
+		{`a\*`, false},
+		{`a\\*`, true},
+	}
+	for _, test := range tests {
+		if got := HasGlob(test.pat); got != test.want {
+			t.Errorf("HasGlob(%q) = %v, want %v", test.pat, got, test.want)
+		}
+	}
+	if got := unescapeGlob(QuoteGlob(`a*b?[c]\`)); got != `a*b?[c]\` {
+		t.Errorf("QuoteGlob doesn't round trip: %q", got)
+	}
+}
+
+func TestGlob(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.go", "b.go", "c.txt", ".hidden", "sub/d.go", "sub/deep/e.go"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		pat      string
+		dotglob  bool
+		globstar bool
+		want     []string
+	}{
+		{"*.go", false, false, []string{"a.go", "b.go"}},
+		{"*", false, false, []string{"a.go", "b.go", "c.txt", "sub"}},
+		{"*", true, false, []string{".hidden", "a.go", "b.go", "c.txt", "sub"}},
+		{".*", false, false, []string{".hidden"}},
+		{"*/", false, false, []string{"sub/"}},
+		{"*/*.go", false, false, []string{"sub/d.go"}},
+		{"sub/*", false, false, []string{"sub/d.go", "sub/deep"}},
+		{"**/*.go", false, true, []string{"a.go", "b.go", "sub/d.go", "sub/deep/e.go"}},
+		{"**/*.go", false, false, []string{"sub/d.go"}},
+		{"[ab].go", false, false, []string{"a.go", "b.go"}},
+		{"nothing*", false, false, nil},
+		{`c.tx\t`, false, false, []string{"c.txt"}},
+	}
+	dlsh := NewExecUnit()
+	dlsh.Dir = dir
+	for _, test := range tests {
+		dlsh.Options["dotglob"] = test.dotglob
+		dlsh.Options["globstar"] = test.globstar
+		if got := dlsh.Glob(test.pat); !slices.Equal(got, test.want) {
+			t.Errorf("Glob(%q) = %q, want %q", test.pat, got, test.want)
+		}
+	}
+
+	abs := dlsh.Glob(filepath.Join(dir, "sub", "*.go"))
+	if want := []string{filepath.Join(dir, "sub", "d.go")}; !slices.Equal(abs, want) {
+		t.Errorf("absolute pattern: got %q, want %q", abs, want)
+	}
+}
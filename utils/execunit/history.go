@@ -0,0 +1,194 @@
+package execunit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	cl "dlsh/utils/cmdline"
+)
+
+const historyUsage = "history: usage: history [-C] [-m string | -e regex] [-p | -j] [n]\n" +
+	"       history -d n | -d first-last | -c | -r | -w | -a"
+
+// The time an entry is listed with, entries without one show a dash
+func histTime(entry *cl.HistEntry) string {
+	if entry.Time.IsZero() {
+		return fmt.Sprintf("%-19s", "-")
+	}
+	return entry.Time.Format("2006-01-02 15:04:05")
+}
+
+func histStatus(err error, stderr *os.File) int {
+	if err != nil {
+		fmt.Fprintf(stderr, "history: %s\n", err.Error())
+		return 1
+	}
+	return 0
+}
+
+// Parses the argument of -d, a number or a range first-last, into indices
+// from first up to but not including last. Negative numbers count back
+// from the end, -1 is the last entry.
+func histRange(arg string, size int) (int, int, bool) {
+	number := func(s string) (int, bool) {
+		n, err := strconv.Atoi(s)
+		if n < 0 {
+			n += size + 1
+		}
+		return n, err == nil && n >= 1 && n <= size
+	}
+	// the first number may be negative too
+	if i := strings.Index(arg[min(1, len(arg)):], "-"); i != -1 {
+		a, okA := number(arg[:i+1])
+		b, okB := number(arg[i+2:])
+		return a - 1, b, okA && okB && a <= b
+	}
+	n, ok := number(arg)
+	return n - 1, n, ok
+}
+
+// history [-C] [-m string | -e regex] [-p | -j] [n]
+// history -d n | -d first-last | -c | -r | -w | -a
+//
+// Lists the last n entries, all by default, with their number and the time
+// they were run. -m and -e keep those holding a string or matching a
+// regex, -C those run in the working directory. -p prints the commands
+// only and -j the entries with all they recorded as JSON.
+//
+// -d deletes an entry or a range of them and -c all of them, from the
+// history file as well. -r reads the entries other sessions added to the
+// history file, -w replaces the file with the history and -a appends the
+// entries of this session that couldn't be written yet.
+func (dlsh *ExecUnit) BuiltinHistory(args []string, stdin, stdout, stderr *os.File) int {
+	if dlsh.History == nil {
+		fmt.Fprintln(stderr, "history: history is only kept by an interactive shell")
+		return 1
+	}
+	hist := dlsh.History
+	args = args[1:]
+
+	var here, plain, asJSON bool
+	var substr string
+	var re *regexp.Regexp
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") && args[0] != "-" {
+		opt := args[0]
+		args = args[1:]
+		if opt == "--" {
+			break
+		}
+		var err error
+		switch opt {
+		case "-r":
+			return histStatus(hist.Read(), stderr)
+		case "-w":
+			return histStatus(hist.Write(), stderr)
+		case "-a":
+			return histStatus(hist.Flush(), stderr)
+		case "-c":
+			return histStatus(hist.Clear(), stderr)
+		case "-d", "-m", "-e":
+			if len(args) == 0 {
+				fmt.Fprintf(stderr, "history: %s: option requires an argument\n%s\n", opt, historyUsage)
+				return 2
+			}
+			arg := args[0]
+			args = args[1:]
+			switch opt {
+			case "-d":
+				first, last, ok := histRange(arg, len(hist.Entries()))
+				if !ok {
+					fmt.Fprintf(stderr, "history: %s: history position out of range\n", arg)
+					return 1
+				}
+				return histStatus(hist.Delete(first, last), stderr)
+			case "-m":
+				substr = arg
+			case "-e":
+				if re, err = regexp.Compile(arg); err != nil {
+					fmt.Fprintf(stderr, "history: %s\n", err.Error())
+					return 2
+				}
+			}
+		case "-C":
+			here = true
+		case "-p":
+			plain = true
+		case "-j":
+			asJSON = true
+		default:
+			fmt.Fprintf(stderr, "history: %s: invalid option\n%s\n", opt, historyUsage)
+			return 2
+		}
+	}
+	count := -1
+	if len(args) > 1 {
+		fmt.Fprintf(stderr, "history: too many arguments\n%s\n", historyUsage)
+		return 2
+	} else if len(args) == 1 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 0 {
+			fmt.Fprintf(stderr, "history: %s: numeric argument required\n", args[0])
+			return 2
+		}
+		count = n
+	}
+
+	// the numbers of the entries kept, they stay those of the whole history
+	var numbers []int
+	cwd := dlsh.Pwd()
+	for i, entry := range hist.Entries() {
+		if (substr != "" && !strings.Contains(entry.Line, substr)) ||
+			(re != nil && !re.MatchString(entry.Line)) ||
+			(here && entry.Cwd != cwd) {
+			continue
+		}
+		numbers = append(numbers, i+1)
+	}
+	if count >= 0 && count < len(numbers) {
+		numbers = numbers[len(numbers)-count:]
+	}
+
+	entries := hist.Entries()
+	switch {
+	case asJSON:
+		type jsonEntry struct {
+			Number   int     `json:"number"`
+			Command  string  `json:"command"`
+			Time     *string `json:"time"`
+			Duration int64   `json:"duration_ms"`
+			Status   int     `json:"status"`
+			Cwd      string  `json:"cwd"`
+			Host     string  `json:"host"`
+			Session  string  `json:"session"`
+		}
+		list := []jsonEntry{}
+		for _, n := range numbers {
+			entry := entries[n-1]
+			var when *string
+			if !entry.Time.IsZero() {
+				s := entry.Time.Format(time.RFC3339)
+				when = &s
+			}
+			list = append(list, jsonEntry{
+				n, entry.Line, when, entry.Duration.Milliseconds(),
+				entry.Status, entry.Cwd, entry.Host, entry.Session,
+			})
+		}
+		out, _ := json.MarshalIndent(list, "", "  ")
+		fmt.Fprintln(stdout, string(out))
+	case plain:
+		for _, n := range numbers {
+			fmt.Fprintln(stdout, entries[n-1].Line)
+		}
+	default:
+		for _, n := range numbers {
+			fmt.Fprintf(stdout, "%5d  %s  %s\n", n, histTime(entries[n-1]), entries[n-1].Line)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,35 @@
+package execunit
+
+import "testing"
+
+func TestHistRange(t *testing.T) {
+	tests := []struct {
+		arg         string
+		first, last int
+		ok          bool
+	}{
+		{"1", 0, 1, true},
+		{"10", 9, 10, true},
+		{"11", 0, 0, false},
+		{"0", 0, 0, false},
+		{"-1", 9, 10, true},
+		{"-10", 0, 1, true},
+		{"-11", 0, 0, false},
+		{"2-4", 1, 4, true},
+		{"3-3", 2, 3, true},
+		{"4-2", 0, 0, false},
+		{"-3--1", 7, 10, true},
+		{"5--1", 4, 10, true},
+		{"2-11", 0, 0, false},
+		{"x", 0, 0, false},
+		{"1-x", 0, 0, false},
+		{"", 0, 0, false},
+	}
+	for _, test := range tests {
+		first, last, ok := histRange(test.arg, 10)
+		if ok != test.ok || (ok && (first != test.first || last != test.last)) {
+			t.Errorf("histRange(%q) = %d, %d, %v, want %d, %d, %v", test.arg,
+				first, last, ok, test.first, test.last, test.ok)
+		}
+	}
+}
@@ -1,13 +1,11 @@
 package execunit
 
 import (
-	ds "dlsh/utils/datastruct"
-	"fmt"
 	"os"
 	"os/exec"
-	"slices"
-	"strings"
 	"syscall"
+
+	"golang.org/x/sys/unix"
 )
 
 type InstructionType uint8
@@ -15,7 +13,6 @@ type InstructionType uint8
 const (
 	EXEC InstructionType = iota
 	PIPE
-	WAIT
 )
 
 type Instruction struct {
@@ -23,17 +20,53 @@ type Instruction struct {
 	Cmd     *exec.Cmd
 	R, W, E *os.File
 	State   bool
+	Stopped bool
+	Status  int
+	// set when the process was killed by a signal, Status is then 128 plus
+	// the signal
+	Signaled bool
+	// applied in order once the pipe ends are in place
+	Redirs []*Redirection
+	// files opened by redirections and pipe ends, closed once the command
+	// is started
+	files []*os.File
+	// expanded NAME=value assignments before the command
+	Assigns []string
+	// status of the last command substitution of the command, 0 if none
+	substStatus int
+	// set when the command is a builtin, it isn't looked up in PATH
+	Builtin Builtin
+	// set when the command is a function, it is looked up first
+	Func *FuncDef
+	// set for a compound command, run by the shell like a builtin
+	Node Node
+	// closed once a builtin run in a goroutine returns
+	done chan struct{}
 }
 type Instructions []*Instruction
 
+// The program of a command that isn't a builtin is looked up by the shell
+// once it knows the PATH the command runs with
 func NewInstruction(path string, args ...string) *Instruction {
-	execCmd := exec.Command(path, args...)
+	builtin, _ := LookupBuiltin(path)
+	execCmd := &exec.Cmd{Path: path, Args: append([]string{path}, args...)}
+	instruction := newInstruction(execCmd)
+	instruction.Builtin = builtin
+	return instruction
+}
+
+// An instruction running the function fn, it isn't looked up in PATH
+func NewFuncInstruction(fn *FuncDef, args ...string) *Instruction {
+	instruction := newInstruction(&exec.Cmd{Path: fn.Name, Args: append([]string{fn.Name}, args...)})
+	instruction.Func = fn
+	return instruction
+}
+
+func newInstruction(execCmd *exec.Cmd) *Instruction {
 	execCmd.Stdout = os.Stdout
 	execCmd.Stdin = os.Stdin
 	execCmd.Stderr = os.Stderr
-	execCmd.SysProcAttr = &syscall.SysProcAttr{
-		Setpgid: true,
-	}
+	execCmd.SysProcAttr = new(syscall.SysProcAttr)
 
 	instruction := new(Instruction)
 	instruction.InsType = EXEC
@@ -59,199 +92,83 @@ func (ins *Instruction) Append(arg string) {
 	}
 }
 
+// Pipes are set up before the redirections, which may then replace or
+// duplicate them. The pipe end is closed along with the redirected files,
+// a nil r leaves the command's stdin alone.
 func (ins *Instruction) PipeRead(r *os.File) {
-	ins.Cmd.Stdin = r
-	ins.R = r
+	if r == nil {
+		return
+	}
+	ins.files = append(ins.files, r)
+	ins.SetFile(0, r)
 }
 
 func (ins *Instruction) PipeWrite(w *os.File) {
-	ins.Cmd.Stdout = w
-	ins.W = w
+	ins.files = append(ins.files, w)
+	ins.SetFile(1, w)
 }
 
-func (ins *Instruction) IsChdir() bool {
-	return ins.Cmd.Path == "cd"
+func (ins *Instruction) CloseFiles() {
+	for _, fp := range ins.files {
+		fp.Close()
+	}
+	ins.files = nil
 }
 
-func (ins *Instruction) Chdir() bool {
-	cmd := ins.Cmd
-	if len(cmd.Args) > 2 {
-		fmt.Println("Too many arguments for cd:", cmd.Args)
-		return false
-	} else {
-		if err := os.Chdir(cmd.Args[1]); err != nil {
-			fmt.Println(err.Error())
-			return false
-		}
+func (ins *Instruction) Name() string {
+	if len(ins.Cmd.Args) == 0 {
+		return ""
 	}
-	return true
+	return ins.Cmd.Args[0]
 }
 
-func (inss *Instructions) Append(ins *Instruction) {
-	*inss = append(*inss, ins)
+// Commands handled by the shell itself: builtins, functions and compound
+// commands. An empty name is a command made of assignments and redirections
+// only.
+func (ins *Instruction) IsBuiltin() bool {
+	return ins.Name() == "" || ins.Builtin != nil || ins.Func != nil || ins.Node != nil
 }
 
-func Tokenize(s *string) []string {
-	var tokens []string
-	var toParse bool
-	var from int
-	var stack *ds.Stack[rune]
-
-	toParse = true
-	from = 0
-	stack = new(ds.Stack[rune])
-
-	ignore := strings.Split(" \t", "")
-	noParse := strings.Split("\"'", "")
-	d := strings.Split("|><&", "")
-	for i, c := range *s {
-		// TODO:
-		// Implement this using a stack
-		if slices.Contains(noParse, string(c)) {
-			if stack.IsEmpty() || stack.Top() == c {
-				if stack.IsEmpty() {
-					stack.Push(c)
-				} else {
-					stack.Pop()
-				}
-				toParse = !toParse
-				continue
-			}
-		}
-
-		if !toParse {
-			continue
-		}
-
-		if slices.Contains(ignore, string(c)) {
-			if i == from {
-				from++
-				continue
-			}
-			tokens = append(tokens, (*s)[from:i])
-			from = i + 1
-			tokens[len(tokens)-1] = os.ExpandEnv(tokens[len(tokens)-1])
-		} else if slices.Contains(d, string(c)) {
-			if c == '&' {
-				if i+2 < len(*s) && (*s)[i+1] == '&' {
-					if i == from {
-						tokens = append(tokens, "&&")
-					} else {
-						tokens = append(tokens, (*s)[from:i], "&&")
-						tokens[len(tokens)-2] = os.ExpandEnv(tokens[len(tokens)-2])
-					}
-					from = i + 2
-				}
-				continue
-			}
-			if i == from {
-				tokens = append(tokens, string(c))
-			} else {
-				tokens = append(tokens, (*s)[from:i], string(c))
-				tokens[len(tokens)-2] = os.ExpandEnv(tokens[len(tokens)-2])
-			}
-			from = i + 1
-		}
-
+// Applies a state change reported by wait4(2). A stopped process keeps its
+// State, its Status is 128 plus the stop signal until it exits.
+func (ins *Instruction) SetWaitStatus(ws unix.WaitStatus) {
+	switch {
+	case ws.Stopped():
+		ins.Stopped = true
+		ins.Status = 128 + int(ws.StopSignal())
+	case ws.Continued():
+		ins.Stopped = false
+	default:
+		ins.State = false
+		ins.Stopped = false
+		ins.Status = WaitStatus(ws)
+		ins.Signaled = ws.Signaled()
+		ins.Cmd.Process.Release()
 	}
-
-	if from != len(*s) {
-		tokens = append(tokens, (*s)[from:])
-		tokens[len(tokens)-1] = os.ExpandEnv(tokens[len(tokens)-1])
-	}
-
-	return tokens
 }
 
-func parseToken(instruction *Instruction, tokens []string) int {
-	var i int
-	var err error
-
-	for i = 0; i < len(tokens); i++ {
-		token := tokens[i]
-		if token == "|" {
-			instruction.InsType = PIPE
-			return i
-		} else if token == "&&" {
-			if i != 0 {
-				return i - 1
-			}
-			instruction.InsType = WAIT
-			return i
-		} else if token == ">" {
-			if i == len(tokens) {
-				fmt.Println("Expected string after >")
-				return -1
-			}
-			instruction.W, err = os.Create(tokens[i+1])
-			if err != nil {
-				panic(err)
-			}
-			instruction.Cmd.Stdout = instruction.W
-			i++
-		} else if token == "<" {
-			if i == len(tokens) {
-				fmt.Println("Expected string after <")
-				return -1
-			}
-			instruction.R, err = os.Open(tokens[i+1])
-			if err != nil {
-				panic(err)
-			}
-			instruction.Cmd.Stdin = instruction.R
-			i++
-		} else {
-			// TODO:
-			// Use a String Builder
-			var modToken []rune
-			stack := new(ds.Stack[rune])
-			escapeChar := false
-			for _, c := range token {
-				if escapeChar {
-					escapeChar = false
-					modToken = append(modToken, c)
-					continue
-				}
-				if c == '\\' {
-					escapeChar = true
-					continue
-				}
-				if c == '~' {
-					modToken = append(modToken, []rune(os.Getenv("HOME"))...)
-					continue
-				}
-				if stack.IsEmpty() && (c == '"' || c == '\'') {
-					stack.Push(c)
-					continue
-				}
-				if !stack.IsEmpty() && c == stack.Top() {
-					stack.Pop()
-					continue
-				}
-				modToken = append(modToken, c)
-			}
-			token = string(modToken)
-			// fmt.Printf("~%s~\n", token)
-			instruction.Append(token)
+// Waits for a state change of the process, returns false if there was none
+func (ins *Instruction) wait(options int) bool {
+	var ws unix.WaitStatus
+	for {
+		pid, err := unix.Wait4(ins.Cmd.Process.Pid, &ws, options, nil)
+		switch {
+		case err == unix.EINTR:
+			continue
+		case err != nil:
+			// ECHILD, it was waited for elsewhere
+			ins.State = false
+			ins.Stopped = false
+			ins.Status = 1
+		case pid == 0:
+			return false
+		default:
+			ins.SetWaitStatus(ws)
 		}
+		return true
 	}
-	return i
 }
 
-func Parse(tokens []string) Instructions {
-	var instructions Instructions
-	var instruction *Instruction
-
-	begin := 0
-	for i := 0; i < len(tokens) && begin < len(tokens); i++ {
-		instruction = NewInstruction("")
-		i = parseToken(instruction, tokens[begin:])
-		if i < 0 {
-			return instructions
-		}
-		instructions.Append(instruction)
-		begin += i + 1
-	}
-
-	return instructions
+func (inss *Instructions) Append(ins *Instruction) {
+	*inss = append(*inss, ins)
 }
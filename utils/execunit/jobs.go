@@ -0,0 +1,540 @@
+package execunit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+	"golang.org/x/term"
+)
+
+type JobState uint8
+
+const (
+	Running JobState = iota
+	Stopped
+	Done
+)
+
+type Job struct {
+	Id     int
+	Pgid   int
+	Cmd    string
+	State  JobState
+	Status int
+	// set when the last process was killed by a signal
+	Signaled bool
+	Ins      Instructions
+	// terminal modes of the job when it was stopped
+	tmodes *term.State
+	// last state reported by Notify
+	shown JobState
+	// Set for jobs run by a subshell goroutine rather than a single
+	// pipeline, closed once the subshell returns
+	done chan struct{}
+	// order of activation, the highest is the current job %+
+	seq uint
+}
+
+func (job *Job) String() string {
+	switch job.State {
+	case Running:
+		return "Running"
+	case Stopped:
+		return "Stopped"
+	}
+	if job.Status == 0 {
+		return "Done"
+	} else if job.Signaled {
+		name := syscall.Signal(job.Status - 128).String()
+		return strings.ToUpper(name[:1]) + name[1:]
+	}
+	return "Exit " + strconv.Itoa(job.Status)
+}
+
+// The pids of the job's processes that were started
+func (job *Job) Pids() []int {
+	var pids []int
+	for _, ins := range job.Ins {
+		if ins.Cmd.Process != nil {
+			pids = append(pids, ins.Cmd.Process.Pid)
+		}
+	}
+	return pids
+}
+
+// Waits for a state change of the job's processes, returns false if nothing
+// changed. They are waited for one by one rather than by group, a group can
+// hold the processes of the subshells of a pipeline too. Blocking, the first
+// running process is waited for, or else the first one alive. With WNOHANG
+// each of them is checked.
+func (job *Job) reap(options int) bool {
+	options |= unix.WUNTRACED | unix.WCONTINUED
+	if options&unix.WNOHANG != 0 {
+		changed := false
+		for _, ins := range job.Ins {
+			if ins.State && ins.wait(options) {
+				changed = true
+			}
+		}
+		return changed
+	}
+	for _, ins := range job.Ins {
+		if ins.State && !ins.Stopped {
+			return ins.wait(options)
+		}
+	}
+	for _, ins := range job.Ins {
+		if ins.State {
+			return ins.wait(options)
+		}
+	}
+	return false
+}
+
+// Reaps the job's processes. If block is set it waits until every process
+// is either done or stopped. Returns true once the job is done, a stopped
+// job has the status of its stopped process.
+func (job *Job) Update(block bool) bool {
+	if job.State == Done {
+		return true
+	}
+	if job.done != nil {
+		if block {
+			<-job.done
+		}
+		select {
+		case <-job.done:
+			job.State = Done
+		default:
+		}
+		return job.State == Done
+	}
+
+	alive := func(ins *Instruction) bool { return ins.State }
+	stopped := func(ins *Instruction) bool { return ins.Stopped }
+	running := func(ins *Instruction) bool { return ins.State && !ins.Stopped }
+	for slices.ContainsFunc(job.Ins, alive) && job.reap(unix.WNOHANG) {
+	}
+	for block && slices.ContainsFunc(job.Ins, running) && job.reap(0) {
+	}
+
+	if slices.ContainsFunc(job.Ins, stopped) {
+		job.State = Stopped
+		for _, ins := range job.Ins {
+			if ins.Stopped {
+				job.Status = ins.Status
+			}
+		}
+		return false
+	} else if slices.ContainsFunc(job.Ins, alive) {
+		job.State = Running
+		return false
+	}
+	job.State = Done
+	if len(job.Ins) > 0 {
+		job.Status = job.Ins[len(job.Ins)-1].Status
+		job.Signaled = job.Ins[len(job.Ins)-1].Signaled
+	}
+	return true
+}
+
+// The process group of the job, without one its first process
+func (job *Job) Pid() int {
+	if job.Pgid != 0 {
+		return job.Pgid
+	}
+	for _, ins := range job.Ins {
+		if ins.Cmd.Process != nil {
+			return ins.Cmd.Process.Pid
+		}
+	}
+	return 0
+}
+
+// Sends sig to the job's process group, or to each of its processes
+// without one
+func (job *Job) Signal(sig syscall.Signal) {
+	if job.Pgid != 0 {
+		unix.Kill(-job.Pgid, sig)
+		return
+	}
+	for _, ins := range job.Ins {
+		if ins.State {
+			unix.Kill(ins.Cmd.Process.Pid, sig)
+		}
+	}
+}
+
+// Resumes a stopped job with SIGCONT
+func (job *Job) Continue() {
+	for _, ins := range job.Ins {
+		ins.Stopped = false
+	}
+	job.State = Running
+	job.shown = Running
+	job.Signal(syscall.SIGCONT)
+}
+
+type JobTable struct {
+	jobs []*Job
+	// disowned jobs are no longer listed, they are only reaped
+	disowned []*Job
+	seq      uint
+}
+
+func NewJobTable() *JobTable {
+	return new(JobTable)
+}
+
+func (jt *JobTable) Jobs() []*Job {
+	return jt.jobs
+}
+
+func (jt *JobTable) Add(job *Job) {
+	job.Id = 1
+	if len(jt.jobs) > 0 {
+		job.Id = jt.jobs[len(jt.jobs)-1].Id + 1
+	}
+	jt.jobs = append(jt.jobs, job)
+	jt.Touch(job)
+}
+
+func (jt *JobTable) Remove(job *Job) {
+	jt.jobs = slices.DeleteFunc(jt.jobs, func(j *Job) bool { return j == job })
+}
+
+func (jt *JobTable) Disown(job *Job) {
+	jt.Remove(job)
+	jt.disowned = append(jt.disowned, job)
+}
+
+// Makes job the current job
+func (jt *JobTable) Touch(job *Job) {
+	jt.seq++
+	job.seq = jt.seq
+}
+
+// The current job %+ and the previous job %-
+func (jt *JobTable) recent() (*Job, *Job) {
+	var cur, prev *Job
+	for _, job := range jt.jobs {
+		if cur == nil || job.seq > cur.seq {
+			cur, prev = job, cur
+		} else if prev == nil || job.seq > prev.seq {
+			prev = job
+		}
+	}
+	return cur, prev
+}
+
+func (jt *JobTable) Mark(job *Job) byte {
+	cur, prev := jt.recent()
+	if job == cur {
+		return '+'
+	} else if job == prev {
+		return '-'
+	}
+	return ' '
+}
+
+// Resolves a job spec: %n, %+, %%, %-, %string (prefix of the command) or
+// %?string (substring of the command). An empty spec is the current job.
+func (jt *JobTable) Find(spec string) (*Job, error) {
+	cur, prev := jt.recent()
+	if spec == "" || spec == "%" || spec == "%%" || spec == "%+" {
+		if cur == nil {
+			return nil, errors.New("no current job")
+		}
+		return cur, nil
+	} else if spec == "%-" {
+		if prev == nil {
+			return nil, errors.New("no previous job")
+		}
+		return prev, nil
+	} else if !strings.HasPrefix(spec, "%") {
+		return nil, fmt.Errorf("%s: no such job", spec)
+	}
+
+	if id, err := strconv.Atoi(spec[1:]); err == nil {
+		for _, job := range jt.jobs {
+			if job.Id == id {
+				return job, nil
+			}
+		}
+		return nil, fmt.Errorf("%s: no such job", spec)
+	}
+
+	var found *Job
+	for _, job := range jt.jobs {
+		var match bool
+		if sub, ok := strings.CutPrefix(spec, "%?"); ok {
+			match = strings.Contains(job.Cmd, sub)
+		} else {
+			match = strings.HasPrefix(job.Cmd, spec[1:])
+		}
+		if !match {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("%s: ambiguous job spec", spec)
+		}
+		found = job
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%s: no such job", spec)
+	}
+	return found, nil
+}
+
+func (jt *JobTable) FindPid(pid int) *Job {
+	for _, job := range jt.jobs {
+		if slices.Contains(job.Pids(), pid) {
+			return job
+		}
+	}
+	return nil
+}
+
+func (jt *JobTable) Format(job *Job) string {
+	return fmt.Sprintf("[%d]%c  %-24s%s", job.Id, jt.Mark(job), job, job.Cmd)
+}
+
+// Reaps jobs without blocking and prints a line for each job that stopped
+// or finished since the last call, finished jobs are dropped from the table
+func (jt *JobTable) Notify(w io.Writer) {
+	jt.disowned = slices.DeleteFunc(jt.disowned, func(job *Job) bool {
+		return job.Update(false)
+	})
+	for _, job := range slices.Clone(jt.jobs) {
+		job.Update(false)
+		if job.State == job.shown {
+			continue
+		}
+		fmt.Fprintln(w, jt.Format(job))
+		job.shown = job.State
+		if job.State == Done {
+			jt.Remove(job)
+		}
+	}
+}
+
+// Takes the terminal back from a stopped foreground job, saving its
+// terminal modes, and adds it to the job table
+func (dlsh *ExecUnit) Suspend(job *Job) {
+	if dlsh.JobControl {
+		dlsh.TakeTerminal()
+		job.tmodes, _ = term.GetState(int(os.Stdin.Fd()))
+		if dlsh.TModes != nil {
+			term.Restore(int(os.Stdin.Fd()), dlsh.TModes)
+		}
+	}
+
+	if !slices.Contains(dlsh.Jobs.Jobs(), job) {
+		dlsh.Jobs.Add(job)
+	}
+	dlsh.Jobs.Touch(job)
+	job.shown = Stopped
+	fmt.Fprintf(dlsh.Stdout, "\n%s\n", dlsh.Jobs.Format(job))
+}
+
+// Hands the terminal to job, resumes it and waits for it to finish or stop
+func (dlsh *ExecUnit) Foreground(job *Job) int {
+	dlsh.Jobs.Touch(job)
+	if job.Pgid != 0 && dlsh.JobControl {
+		if job.tmodes != nil {
+			term.Restore(int(os.Stdin.Fd()), job.tmodes)
+		}
+		dlsh.GiveTerminal(job.Pgid)
+	}
+	job.Continue()
+	job.Update(true)
+	if job.State == Stopped {
+		dlsh.Suspend(job)
+		return job.Status
+	}
+
+	if job.Pgid != 0 && dlsh.JobControl {
+		dlsh.TakeTerminal()
+	}
+	dlsh.Jobs.Remove(job)
+	return job.Status
+}
+
+// jobs [-l | -p] [jobspec ...]
+func (dlsh *ExecUnit) BuiltinJobs(args []string, stdin, stdout, stderr *os.File) int {
+	var list []*Job
+	var long, pids bool
+	status := 0
+	for _, arg := range args[1:] {
+		switch arg {
+		case "-l":
+			long = true
+		case "-p":
+			pids = true
+		default:
+			job, err := dlsh.Jobs.Find(arg)
+			if err != nil {
+				fmt.Fprintln(stderr, "jobs: "+err.Error())
+				status = 1
+				continue
+			}
+			list = append(list, job)
+		}
+	}
+	if list == nil && status == 0 {
+		list = dlsh.Jobs.Jobs()
+	}
+
+	for _, job := range slices.Clone(list) {
+		job.Update(false)
+		if pids {
+			fmt.Fprintln(stdout, job.Pid())
+		} else if long {
+			fmt.Fprintf(stdout, "[%d]%c %d %-24s%s\n", job.Id, dlsh.Jobs.Mark(job), job.Pid(), job, job.Cmd)
+		} else {
+			fmt.Fprintln(stdout, dlsh.Jobs.Format(job))
+		}
+		job.shown = job.State
+		if job.State == Done {
+			dlsh.Jobs.Remove(job)
+		}
+	}
+	return status
+}
+
+// fg [jobspec]
+func (dlsh *ExecUnit) BuiltinFg(args []string, stdin, stdout, stderr *os.File) int {
+	job, err := dlsh.Jobs.Find(strings.Join(args[1:], " "))
+	if err != nil {
+		fmt.Fprintln(stderr, "fg: "+err.Error())
+		return 1
+	}
+	fmt.Fprintln(stdout, job.Cmd)
+	return dlsh.Foreground(job)
+}
+
+// bg [jobspec ...]
+func (dlsh *ExecUnit) BuiltinBg(args []string, stdin, stdout, stderr *os.File) int {
+	specs := args[1:]
+	if len(specs) == 0 {
+		specs = []string{""}
+	}
+	status := 0
+	for _, spec := range specs {
+		job, err := dlsh.Jobs.Find(spec)
+		if err != nil {
+			fmt.Fprintln(stderr, "bg: "+err.Error())
+			status = 1
+			continue
+		}
+		job.Continue()
+		fmt.Fprintf(stdout, "[%d]%c %s &\n", job.Id, dlsh.Jobs.Mark(job), job.Cmd)
+	}
+	return status
+}
+
+// Waits for a running job to finish or stop. An interactive shell stops
+// waiting on SIGINT, which is reported as false.
+func (dlsh *ExecUnit) waitJob(job *Job) bool {
+	if !dlsh.Interactive {
+		job.Update(true)
+		return true
+	}
+	intr := make(chan os.Signal, 1)
+	signal.Notify(intr, syscall.SIGINT)
+	defer signal.Stop(intr)
+	// woken up as a process changes state, or a subshell returns
+	chld := make(chan os.Signal, 1)
+	signal.Notify(chld, syscall.SIGCHLD)
+	defer signal.Stop(chld)
+	for job.Update(false); job.State == Running; job.Update(false) {
+		select {
+		case <-intr:
+			dlsh.Interrupt()
+			return false
+		case <-chld:
+		case <-job.done:
+		}
+	}
+	return true
+}
+
+// wait [jobspec | pid ...], without arguments waits for every running job.
+// Stopped jobs are left in the table. A ^C ends it with status 130.
+func (dlsh *ExecUnit) BuiltinWait(args []string, stdin, stdout, stderr *os.File) int {
+	if len(args) == 1 {
+		for _, job := range slices.Clone(dlsh.Jobs.Jobs()) {
+			if !dlsh.waitJob(job) {
+				return 130
+			}
+			if job.State == Done {
+				dlsh.Jobs.Remove(job)
+			}
+		}
+		return 0
+	}
+
+	status := 0
+	for _, arg := range args[1:] {
+		var job *Job
+		if strings.HasPrefix(arg, "%") {
+			var err error
+			if job, err = dlsh.Jobs.Find(arg); err != nil {
+				fmt.Fprintln(stderr, "wait: "+err.Error())
+				status = 127
+				continue
+			}
+		} else if pid, err := strconv.Atoi(arg); err == nil {
+			if job = dlsh.Jobs.FindPid(pid); job == nil {
+				fmt.Fprintf(stderr, "wait: pid %d is not a child of this shell\n", pid)
+				status = 127
+				continue
+			}
+		} else {
+			fmt.Fprintf(stderr, "wait: `%s': not a pid or valid job spec\n", arg)
+			status = 2
+			continue
+		}
+		if !dlsh.waitJob(job) {
+			return 130
+		}
+		if job.State == Done {
+			dlsh.Jobs.Remove(job)
+		}
+		status = job.Status
+	}
+	return status
+}
+
+// disown [-a] [jobspec ...]
+func (dlsh *ExecUnit) BuiltinDisown(args []string, stdin, stdout, stderr *os.File) int {
+	specs := args[1:]
+	if len(specs) == 0 {
+		specs = []string{""}
+	}
+	status := 0
+	for _, spec := range specs {
+		if spec == "-a" {
+			for _, job := range slices.Clone(dlsh.Jobs.Jobs()) {
+				dlsh.Jobs.Disown(job)
+			}
+			continue
+		}
+		job, err := dlsh.Jobs.Find(spec)
+		if err != nil {
+			fmt.Fprintln(stderr, "disown: "+err.Error())
+			status = 1
+			continue
+		}
+		dlsh.Jobs.Disown(job)
+	}
+	return status
+}
@@ -0,0 +1,50 @@
+package execunit
+
+import "testing"
+
+func TestJobs(t *testing.T) {
+	// the sleeps are killed so that the tests don't wait for them
+	sleep := "sleep 5 >/dev/null 2>&1 & "
+	testShell(t, []shellTest{
+		{sleep + "jobs; kill $!", "[1]+  Running                 sleep 5 >/dev/null 2>&1\n", "", 0},
+		{sleep + "jobs -p | wc -l; jobs -l | wc -w; kill $!", "1\n7\n", "", 0},
+		{sleep + "echo $! | grep -c '^[0-9][0-9]*$'; kill $!", "1\n", "", 0},
+		{sleep + sleep + "jobs %1 %2 | cut -c1-4; jobs -p | xargs kill",
+			"[1]-\n[2]+\n", "", 0},
+		{sleep + "disown; jobs; kill $!; wait; echo $?", "0\n", "", 0},
+		{sleep + "p=$!; " + sleep + "disown %1; jobs | cut -c1-4; kill $p $!", "[2]+\n", "", 0},
+		{"echo a & wait; jobs", "a\n", "", 0},
+		{"sh -c 'exit 5' & fg; echo $?", "sh -c 'exit 5'\n5\n", "", 0},
+		{"f() { echo $1; }; f a & wait", "a\n", "", 0},
+		{"{ echo a; } & wait; (echo b) & wait", "a\nb\n", "", 0},
+		{"fg", "", "fg: no current job\n", 1},
+		{"bg", "", "bg: no current job\n", 1},
+		{"jobs %1", "", "jobs: %1: no such job\n", 1},
+		{"disown %3", "", "disown: %3: no such job\n", 1},
+	})
+}
+
+func TestWait(t *testing.T) {
+	testShell(t, []shellTest{
+		{"sh -c 'exit 3' & wait $!; echo $?", "3\n", "", 0},
+		{"sh -c 'exit 3' & wait %1; echo $?; jobs", "3\n", "", 0},
+		{"sleep 0.1 & sh -c 'exit 3' & wait; echo $?; jobs", "0\n", "", 0},
+		{"wait %1", "", "wait: %1: no such job\n", 127},
+		{"sh -c 'kill -STOP $$' & wait; jobs; kill -KILL $!",
+			"[1]+  Stopped                 sh -c 'kill -STOP $$'\n", "", 0},
+	})
+	// the ^C goes to the shell, which is the test here
+	testInteractive(t, []shellTest{
+		{"sleep 2 >/dev/null 2>&1 & sh -c 'sleep 0.3; kill -INT $PPID' & wait; echo no", "", "", 130},
+	})
+}
+
+// A job stopped by a signal stays in the table until it is continued
+func TestStopped(t *testing.T) {
+	stop := "sh -c 'kill -STOP $$; echo a; exit 6' & wait; "
+	testShell(t, []shellTest{
+		{stop + "jobs; kill -KILL $!", "[1]+  Stopped                 sh -c 'kill -STOP $$; echo a; exit 6'\n", "", 0},
+		{stop + "fg; echo $?", "sh -c 'kill -STOP $$; echo a; exit 6'\na\n6\n", "", 0},
+		{stop + "bg; wait %1; echo $?; jobs", "[1]+ sh -c 'kill -STOP $$; echo a; exit 6' &\na\n6\n", "", 0},
+	})
+}
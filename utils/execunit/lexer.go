@@ -0,0 +1,389 @@
+package execunit
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type TokenType uint8
+
+const (
+	WORD TokenType = iota
+	OPERATOR
+	REDIRECTION
+	NEWLINE
+	EOF
+)
+
+func (t TokenType) String() string {
+	switch t {
+	case WORD:
+		return "word"
+	case OPERATOR:
+		return "operator"
+	case REDIRECTION:
+		return "redirection"
+	case NEWLINE:
+		return "newline"
+	case EOF:
+		return "end of input"
+	}
+	return "unknown"
+}
+
+// Offset is in bytes, Line and Col start at 1
+type Pos struct {
+	Offset int
+	Line   int
+	Col    int
+}
+
+func (pos Pos) String() string {
+	return fmt.Sprintf("%d:%d", pos.Line, pos.Col)
+}
+
+type Token struct {
+	Type TokenType
+	Val  string
+	Pos  Pos
+}
+
+func (tok Token) String() string {
+	if tok.Type == NEWLINE || tok.Type == EOF {
+		return tok.Type.String()
+	}
+	return tok.Val
+}
+
+type SyntaxError struct {
+	Pos Pos
+	Msg string
+	// Incomplete is set when more input could still make the source valid,
+	// e.g. an unterminated quote or a trailing `|`
+	Incomplete bool
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("dlsh: syntax error at %s: %s", e.Pos, e.Msg)
+}
+
+// Reports whether err is a syntax error that more input could fix
+func IsIncomplete(err error) bool {
+	var serr *SyntaxError
+	return errors.As(err, &serr) && serr.Incomplete
+}
+
+// Longest first, the lexer picks the first match. Redirections are matched
+// before operators so that &> isn't read as &.
+var operators = []string{"&&", "||", "|", ";;", ";", "&", "(", ")"}
+var redirections = []string{
+	"&>>", "&>", "<<<", "<<-", "<<", ">>", ">|", ">&", "<&", "<>", ">", "<",
+}
+
+type Lexer struct {
+	src string
+	pos Pos
+	// here-documents whose body starts after the current line
+	docs []*HereDoc
+	// aliases and the ones being expanded, see alias
+	aliases map[string]string
+	active  []activeAlias
+}
+
+func NewLexer(src string) *Lexer {
+	lex := new(Lexer)
+	lex.src = src
+	lex.pos = Pos{Offset: 0, Line: 1, Col: 1}
+	return lex
+}
+
+func isMeta(c byte) bool {
+	return strings.IndexByte(" \t\n|&;<>()", c) != -1
+}
+
+func (lex *Lexer) eof() bool {
+	return lex.pos.Offset >= len(lex.src)
+}
+
+func (lex *Lexer) peek() byte {
+	if lex.eof() {
+		return 0
+	}
+	return lex.src[lex.pos.Offset]
+}
+
+func (lex *Lexer) advance() {
+	if lex.eof() {
+		return
+	}
+	if lex.src[lex.pos.Offset] == '\n' {
+		lex.pos.Line++
+		lex.pos.Col = 0
+	}
+	lex.pos.Offset++
+	lex.pos.Col++
+}
+
+func (lex *Lexer) advanceN(n int) {
+	for range n {
+		lex.advance()
+	}
+}
+
+func (lex *Lexer) errorf(pos Pos, incomplete bool, format string, a ...any) error {
+	return &SyntaxError{Pos: pos, Msg: fmt.Sprintf(format, a...), Incomplete: incomplete}
+}
+
+// Skips blanks, comments and escaped newlines
+func (lex *Lexer) skipBlank() {
+	for !lex.eof() {
+		c := lex.peek()
+		if c == ' ' || c == '\t' {
+			lex.advance()
+		} else if c == '\\' && strings.HasPrefix(lex.src[lex.pos.Offset:], "\\\n") {
+			lex.advanceN(2)
+		} else if c == '#' {
+			for !lex.eof() && lex.peek() != '\n' {
+				lex.advance()
+			}
+		} else {
+			return
+		}
+	}
+}
+
+func (lex *Lexer) Next() (Token, error) {
+	lex.skipBlank()
+	tok := Token{Pos: lex.pos}
+	if lex.eof() {
+		if len(lex.docs) > 0 {
+			return tok, lex.errorf(lex.docs[0].Pos, true,
+				"here-document delimited by end of input (wanted `%s')", lex.docs[0].Delim)
+		}
+		tok.Type = EOF
+		return tok, nil
+	}
+
+	rest := lex.src[lex.pos.Offset:]
+	if rest[0] == '\n' {
+		lex.advance()
+		tok.Type = NEWLINE
+		tok.Val = "\n"
+		return tok, lex.hereDocs()
+	}
+	// An io number is only recognised right before the redirection, as in 2>
+	digits := 0
+	for digits < len(rest) && rest[digits] >= '0' && rest[digits] <= '9' {
+		digits++
+	}
+	for _, op := range redirections {
+		if strings.HasPrefix(rest[digits:], op) && (digits == 0 || op[0] != '&') {
+			lex.advanceN(digits + len(op))
+			tok.Type = REDIRECTION
+			tok.Val = rest[:digits+len(op)]
+			return tok, nil
+		}
+	}
+	for _, op := range operators {
+		if strings.HasPrefix(rest, op) {
+			lex.advanceN(len(op))
+			tok.Type = OPERATOR
+			tok.Val = op
+			return tok, nil
+		}
+	}
+	if isMeta(rest[0]) {
+		lex.advance()
+		return tok, lex.errorf(tok.Pos, false, "unexpected character %q", rest[0])
+	}
+
+	err := lex.word()
+	tok.Type = WORD
+	tok.Val = lex.src[tok.Pos.Offset:lex.pos.Offset]
+	return tok, err
+}
+
+// Scans a word up to the next unquoted metacharacter, the quotes are kept
+// in the token value and removed during expansion
+func (lex *Lexer) word() error {
+	for !lex.eof() {
+		switch c := lex.peek(); {
+		case c == '\\':
+			if lex.pos.Offset+1 == len(lex.src) {
+				return lex.errorf(lex.pos, true, "unexpected end of input after \\")
+			}
+			lex.advanceN(2)
+		case c == '\'':
+			if err := lex.singleQuoted(); err != nil {
+				return err
+			}
+		case c == '"':
+			if err := lex.doubleQuoted(); err != nil {
+				return err
+			}
+		case c == '`':
+			if err := lex.backquoted(); err != nil {
+				return err
+			}
+		case c == '$':
+			if err := lex.dollar(); err != nil {
+				return err
+			}
+		case isMeta(c):
+			return nil
+		default:
+			lex.advance()
+		}
+	}
+	return nil
+}
+
+// Reads the bodies of the here-documents started on the line just ended,
+// each one up to the line holding only its delimiter
+func (lex *Lexer) hereDocs() error {
+	for _, doc := range lex.docs {
+		var body strings.Builder
+		for {
+			if lex.eof() {
+				return lex.errorf(doc.Pos, true,
+					"here-document delimited by end of input (wanted `%s')", doc.Delim)
+			}
+			rest := lex.src[lex.pos.Offset:]
+			line, _, _ := strings.Cut(rest, "\n")
+			lex.advanceN(min(len(line)+1, len(rest)))
+			if doc.StripTabs {
+				line = strings.TrimLeft(line, "\t")
+			}
+			if line == doc.Delim {
+				break
+			}
+			body.WriteString(line + "\n")
+		}
+		doc.Body = body.String()
+	}
+	lex.docs = nil
+	return nil
+}
+
+func (lex *Lexer) singleQuoted() error {
+	start := lex.pos
+	lex.advance()
+	for !lex.eof() {
+		if lex.peek() == '\'' {
+			lex.advance()
+			return nil
+		}
+		lex.advance()
+	}
+	return lex.errorf(start, true, "unterminated single quote")
+}
+
+func (lex *Lexer) doubleQuoted() error {
+	start := lex.pos
+	lex.advance()
+	for !lex.eof() {
+		switch lex.peek() {
+		case '"':
+			lex.advance()
+			return nil
+		case '\\':
+			lex.advanceN(2)
+		case '`':
+			if err := lex.backquoted(); err != nil {
+				return err
+			}
+		case '$':
+			if err := lex.dollar(); err != nil {
+				return err
+			}
+		default:
+			lex.advance()
+		}
+	}
+	return lex.errorf(start, true, "unterminated double quote")
+}
+
+// $(...) and ${...} may hold blanks and metacharacters, anything else is
+// part of the word
+func (lex *Lexer) dollar() error {
+	rest := lex.src[lex.pos.Offset:]
+	switch {
+	case strings.HasPrefix(rest, "$("):
+		// aliases are expanded when the command is parsed again to run it
+		aliases := lex.aliases
+		lex.aliases = nil
+		_, err := lex.subst()
+		lex.aliases = aliases
+		return err
+	case strings.HasPrefix(rest, "${"):
+		return lex.braced()
+	}
+	lex.advance()
+	return nil
+}
+
+func (lex *Lexer) braced() error {
+	start := lex.pos
+	lex.advanceN(2)
+	for !lex.eof() {
+		var err error
+		switch lex.peek() {
+		case '}':
+			lex.advance()
+			return nil
+		case '\\':
+			lex.advanceN(2)
+		case '\'':
+			err = lex.singleQuoted()
+		case '"':
+			err = lex.doubleQuoted()
+		case '`':
+			err = lex.backquoted()
+		case '$':
+			err = lex.dollar()
+		default:
+			lex.advance()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return lex.errorf(start, true, "unterminated parameter expansion")
+}
+
+func (lex *Lexer) backquoted() error {
+	start := lex.pos
+	lex.advance()
+	for !lex.eof() {
+		switch lex.peek() {
+		case '`':
+			lex.advance()
+			return nil
+		case '\\':
+			lex.advanceN(2)
+		default:
+			lex.advance()
+		}
+	}
+	return lex.errorf(start, true, "unterminated command substitution")
+}
+
+// Parses the command of a $(...) substitution, the lexer is left right after
+// its closing parenthesis
+func (lex *Lexer) subst() (*Sequence, error) {
+	start := lex.pos
+	lex.advanceN(2)
+	p := &Parser{lex: lex, aliasNext: -1}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	seq, err := p.sequence(")")
+	if err != nil {
+		return nil, err
+	}
+	if !p.isOp(")") {
+		return nil, lex.errorf(start, true, "unterminated command substitution")
+	}
+	return seq, nil
+}
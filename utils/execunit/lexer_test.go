@@ -0,0 +1,100 @@
+package execunit
+
+import "testing"
+
+func TestLexer(t *testing.T) {
+	type tok struct {
+		typ TokenType
+		val string
+	}
+	tests := []struct {
+		src  string
+		want []tok
+	}{
+		{"echo  a\tb", []tok{{WORD, "echo"}, {WORD, "a"}, {WORD, "b"}}},
+		{"a'b c'\"d e\"", []tok{{WORD, `a'b c'"d e"`}}},
+		{`a\ b`, []tok{{WORD, `a\ b`}}},
+		{"a&&b||c|d;e&", []tok{
+			{WORD, "a"}, {OPERATOR, "&&"}, {WORD, "b"}, {OPERATOR, "||"}, {WORD, "c"},
+			{OPERATOR, "|"}, {WORD, "d"}, {OPERATOR, ";"}, {WORD, "e"}, {OPERATOR, "&"},
+		}},
+		{"a >f 2>&1 <in", []tok{
+			{WORD, "a"}, {REDIRECTION, ">"}, {WORD, "f"}, {REDIRECTION, "2>&"}, {WORD, "1"},
+			{REDIRECTION, "<"}, {WORD, "in"},
+		}},
+		{"a &>f", []tok{{WORD, "a"}, {REDIRECTION, "&>"}, {WORD, "f"}}},
+		{"$(a b) `c d` ${e f}", []tok{{WORD, "$(a b)"}, {WORD, "`c d`"}, {WORD, "${e f}"}}},
+		{"a # comment\nb", []tok{{WORD, "a"}, {NEWLINE, "\n"}, {WORD, "b"}}},
+		{"(a)", []tok{{OPERATOR, "("}, {WORD, "a"}, {OPERATOR, ")"}}},
+		{"case x in a) b;; esac", []tok{
+			{WORD, "case"}, {WORD, "x"}, {WORD, "in"}, {WORD, "a"}, {OPERATOR, ")"},
+			{WORD, "b"}, {OPERATOR, ";;"}, {WORD, "esac"},
+		}},
+	}
+	for _, test := range tests {
+		lex := NewLexer(test.src)
+		var got []tok
+		for {
+			token, err := lex.Next()
+			if err != nil {
+				t.Fatalf("%q: %v", test.src, err)
+			}
+			if token.Type == EOF {
+				break
+			}
+			got = append(got, tok{token.Type, token.Val})
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("%q: got %v, want %v", test.src, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%q: token %d is %v %q, want %v %q", test.src, i,
+					got[i].typ, got[i].val, test.want[i].typ, test.want[i].val)
+			}
+		}
+	}
+}
+
+func TestLexerPos(t *testing.T) {
+	lex := NewLexer("a\n  bc d")
+	want := []Pos{{0, 1, 1}, {1, 1, 2}, {4, 2, 3}, {7, 2, 6}}
+	for _, pos := range want {
+		token, err := lex.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token.Pos != pos {
+			t.Errorf("%q at %+v, want %+v", token.Val, token.Pos, pos)
+		}
+	}
+}
+
+func TestLexerErrors(t *testing.T) {
+	tests := []struct {
+		src        string
+		incomplete bool
+	}{
+		{"echo 'a", true},
+		{`echo "a`, true},
+		{"echo $(a", true},
+		{"echo `a", true},
+		{"echo ${a", true},
+	}
+	for _, test := range tests {
+		lex := NewLexer(test.src)
+		var err error
+		for err == nil {
+			var token Token
+			if token, err = lex.Next(); token.Type == EOF {
+				break
+			}
+		}
+		if err == nil {
+			t.Errorf("%q: no error", test.src)
+		} else if IsIncomplete(err) != test.incomplete {
+			t.Errorf("%q: incomplete is %v, want %v", test.src, IsIncomplete(err), test.incomplete)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package execunit
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Options settable with shopt, all off by default
+var shoptNames = []string{"dotglob", "failglob", "globstar", "histshare", "nullglob"}
+
+// Options settable with set -o
+var setNames = []string{"pipefail"}
+
+type Options map[string]bool
+
+func NewOptions() Options {
+	opts := make(Options)
+	for _, name := range append(shoptNames, setNames...) {
+		opts[name] = false
+	}
+	return opts
+}
+
+func (opts Options) Clone() Options {
+	return maps.Clone(opts)
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+// shopt [-s | -u] [-q] [optname ...]
+func (dlsh *ExecUnit) BuiltinShopt(args []string, stdin, stdout, stderr *os.File) int {
+	var set, unset, quiet bool
+	var names []string
+	for _, arg := range args[1:] {
+		switch arg {
+		case "-s":
+			set = true
+		case "-u":
+			unset = true
+		case "-q":
+			quiet = true
+		default:
+			names = append(names, arg)
+		}
+	}
+	if set && unset {
+		fmt.Fprintln(stderr, "shopt: cannot set and unset shell options simultaneously")
+		return 1
+	}
+
+	status := 0
+	for _, name := range names {
+		if !slices.Contains(shoptNames, name) {
+			fmt.Fprintf(stderr, "shopt: %s: invalid shell option name\n", name)
+			status = 1
+		}
+	}
+	if status != 0 {
+		return status
+	}
+	// without names -s and -u list the options set or unset
+	if names == nil {
+		for _, name := range shoptNames {
+			if (set && dlsh.Options[name]) || (unset && !dlsh.Options[name]) || !(set || unset) {
+				fmt.Fprintf(stdout, "%-16s%s\n", name, onOff(dlsh.Options[name]))
+			}
+		}
+		return 0
+	}
+
+	for _, name := range names {
+		switch {
+		case set:
+			dlsh.Options[name] = true
+		case unset:
+			dlsh.Options[name] = false
+		case quiet:
+			if !dlsh.Options[name] {
+				status = 1
+			}
+		default:
+			fmt.Fprintf(stdout, "%-16s%s\n", name, onOff(dlsh.Options[name]))
+			if !dlsh.Options[name] {
+				status = 1
+			}
+		}
+	}
+	return status
+}
+
+// set [-o | +o [option]] [--] [arg ...]
+//
+// Without arguments the variables are printed. Arguments left after the
+// options, or after --, replace the positional parameters.
+func (dlsh *ExecUnit) BuiltinSet(args []string, stdin, stdout, stderr *os.File) int {
+	args = args[1:]
+	if len(args) == 0 {
+		for _, name := range dlsh.Vars.Names() {
+			value, _ := dlsh.Vars.Get(name)
+			fmt.Fprintf(stdout, "%s=%s\n", name, quoteValue(value))
+		}
+		return 0
+	}
+
+	for len(args) > 0 && (args[0] == "-o" || args[0] == "+o") {
+		on := args[0] == "-o"
+		if len(args) == 1 {
+			for _, name := range setNames {
+				if on {
+					fmt.Fprintf(stdout, "%-16s%s\n", name, onOff(dlsh.Options[name]))
+				} else if dlsh.Options[name] {
+					fmt.Fprintf(stdout, "set -o %s\n", name)
+				} else {
+					fmt.Fprintf(stdout, "set +o %s\n", name)
+				}
+			}
+			return 0
+		}
+		if !slices.Contains(setNames, args[1]) {
+			fmt.Fprintf(stderr, "set: %s: invalid option name\n", args[1])
+			return 1
+		}
+		dlsh.Options[args[1]] = on
+		args = args[2:]
+	}
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	} else if len(args) == 0 {
+		return 0
+	} else if strings.HasPrefix(args[0], "-") || strings.HasPrefix(args[0], "+") {
+		fmt.Fprintf(stderr, "set: %s: invalid option\n", args[0])
+		return 2
+	}
+	dlsh.Args = append([]string{dlsh.Args[0]}, args...)
+	return 0
+}
@@ -0,0 +1,28 @@
+package execunit
+
+import "testing"
+
+func TestPipeStatus(t *testing.T) {
+	testShell(t, []shellTest{
+		{"sh -c 'exit 3'; echo $?", "3\n", "", 0},
+		{"sh -c 'exit 300'; echo $?", "44\n", "", 0},
+		{"sh -c 'kill -TERM $$'; echo $?", "143\n", "", 0},
+		{"sh -c 'kill -KILL $$'; echo $?", "137\n", "", 0},
+		{"echo $?; false; echo $?; echo $?", "0\n1\n0\n", "", 0},
+		{"false | true; echo $?", "0\n", "", 0},
+		{"true | false; echo $?", "1\n", "", 0},
+		{"sh -c 'exit 2' | sh -c 'exit 3' | true; echo ${PIPESTATUS[@]}", "2 3 0\n", "", 0},
+		{"false | true; echo ${PIPESTATUS[0]} ${PIPESTATUS[1]} ${#PIPESTATUS[@]}", "1 0 2\n", "", 0},
+		{"false; echo ${PIPESTATUS[@]}", "1\n", "", 0},
+		{"! false | true; echo $? ${PIPESTATUS[@]}", "1 1 0\n", "", 0},
+		{"set -o pipefail; sh -c 'exit 2' | sh -c 'exit 3' | true; echo $?", "3\n", "", 0},
+		{"set -o pipefail; false | true; echo $?", "1\n", "", 0},
+		{"set -o pipefail; true | true; echo $?", "0\n", "", 0},
+		{"set -o pipefail; ! false | true; echo $?", "0\n", "", 0},
+		{"set -o pipefail; set +o pipefail; false | true; echo $?", "0\n", "", 0},
+		{"set -o pipefail; set -o", "pipefail        on\n", "", 0},
+		{"set -o nosuch", "", "set: nosuch: invalid option name\n", 1},
+		{"false | sh -c 'exit 4'", "", "", 4},
+		{"nosuch | true; echo $? ${PIPESTATUS[@]}", "0 127 0\n", "dlsh: nosuch: command not found\n", 0},
+	})
+}
@@ -0,0 +1,491 @@
+package execunit
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Parameters named by a single character other than a letter
+const specialParams = "?$!#@*-0123456789"
+
+// Index of the `}` closing the brace at src[start], -1 if there is none.
+// Quotes, escapes and nested expansions are skipped over.
+func braceEnd(src string, start int) int {
+	depth := 0
+	for i := start; i < len(src); i++ {
+		switch src[i] {
+		case '\\':
+			i++
+		case '\'':
+			end := strings.IndexByte(src[i+1:], '\'')
+			if end < 0 {
+				return -1
+			}
+			i += end + 1
+		case '"':
+			for i++; i < len(src) && src[i] != '"'; i++ {
+				if src[i] == '\\' {
+					i++
+				}
+			}
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// Index of the first unquoted sep in s, -1 if there is none
+func indexUnquoted(s string, sep byte) int {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == sep:
+			return i
+		case c == '\\':
+			i++
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(s[i+1:], c)
+			if end < 0 {
+				return -1
+			}
+			i += end + 1
+		case c == '$' && strings.HasPrefix(s[i:], "${"):
+			if end := braceEnd(s, i+1); end > 0 {
+				i = end
+			}
+		}
+	}
+	return -1
+}
+
+// The value of a parameter and whether it is set. $@ and $* are the
+// positional parameters joined with spaces.
+func (dlsh *ExecUnit) lookup(name string) (string, bool) {
+	switch name {
+	case "?":
+		return strconv.Itoa(dlsh.Status), true
+	case "$":
+		return strconv.Itoa(os.Getpid()), true
+	case "!":
+		if dlsh.LastBg == 0 {
+			return "", false
+		}
+		return strconv.Itoa(dlsh.LastBg), true
+	case "#":
+		return strconv.Itoa(len(dlsh.Args) - 1), true
+	case "-":
+		return "", true
+	case "@", "*":
+		return strings.Join(dlsh.Args[1:], " "), len(dlsh.Args) > 1
+	}
+	if n, err := strconv.Atoi(name); err == nil {
+		if n < len(dlsh.Args) {
+			return dlsh.Args[n], true
+		}
+		return "", false
+	}
+	if values, ok := dlsh.array(name); ok {
+		return values[0], true
+	}
+	return dlsh.Vars.Get(name)
+}
+
+// The elements of an array parameter, PIPESTATUS is the only one. A
+// variable is taken as an array of one element.
+func (dlsh *ExecUnit) array(name string) ([]string, bool) {
+	if name == "PIPESTATUS" && len(dlsh.PipeStatus) > 0 {
+		var values []string
+		for _, status := range dlsh.PipeStatus {
+			values = append(values, strconv.Itoa(status))
+		}
+		return values, true
+	}
+	if value, ok := dlsh.Vars.Get(name); ok {
+		return []string{value}, true
+	}
+	return nil, false
+}
+
+func isParam(name string) bool {
+	if len(name) == 1 && strings.IndexByte(specialParams, name[0]) != -1 {
+		return true
+	}
+	if _, err := strconv.Atoi(name); err == nil {
+		return !strings.HasPrefix(name, "-") && !strings.HasPrefix(name, "+")
+	}
+	if name == "" || !isNameStart(name[0]) {
+		return false
+	}
+	for i := range len(name) {
+		if !isNameChar(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Writes the value of a parameter, see elements for $@ and $*
+func (exp *expander) param(name string) {
+	if name == "@" || name == "*" {
+		exp.elements(exp.dlsh.Args[1:], name == "*")
+		return
+	}
+	value, _ := exp.dlsh.lookup(name)
+	exp.expansion(value)
+}
+
+// Writes a list of values, such as the positional parameters. Each value is
+// a field of its own, inside double quotes too unless star is set: then
+// they are joined with the first character of IFS.
+func (exp *expander) elements(values []string, star bool) {
+	if len(values) == 0 {
+		if !star && exp.quoted && exp.sb.Len() == 0 {
+			// "$@" without positional parameters is no field at all
+			exp.started = false
+		}
+		return
+	}
+	if exp.quoted && (star || !exp.split) {
+		sep := " "
+		if ifs, ok := exp.dlsh.Vars.Get("IFS"); ok {
+			sep = ifs[:min(len(ifs), 1)]
+		}
+		exp.expansion(strings.Join(values, sep))
+		return
+	}
+	for i, value := range values {
+		if i > 0 && (exp.quoted || exp.started) {
+			exp.endField()
+		}
+		exp.expansion(value)
+	}
+}
+
+// ${name[subscript]}, @ and * stand for all the elements as with $@ and $*
+func (exp *expander) element(body, name, sub string) {
+	values, _ := exp.dlsh.array(name)
+	if sub == "@" || sub == "*" {
+		exp.elements(values, sub == "*")
+		return
+	}
+	exp.expansion(exp.index(body, values, sub))
+}
+
+// The element at the index sub, "" if there is none. A negative index
+// counts from the end.
+func (exp *expander) index(body string, values []string, sub string) string {
+	n, err := strconv.Atoi(strings.TrimSpace(exp.dlsh.expandString(sub, false)))
+	if err != nil {
+		exp.badSubst(body)
+		return ""
+	}
+	if n < 0 {
+		n += len(values)
+	}
+	if n < 0 || n >= len(values) {
+		return ""
+	}
+	return values[n]
+}
+
+// Splits name[subscript], ok is unset if s has no subscript
+func splitSubscript(s string) (name, sub string, ok bool) {
+	open := strings.IndexByte(s, '[')
+	if open <= 0 || !strings.HasSuffix(s, "]") || !isParam(s[:open]) || !isNameStart(s[0]) {
+		return "", "", false
+	}
+	return s[:open], s[open+1 : len(s)-1], true
+}
+
+func (exp *expander) badSubst(body string) {
+	if exp.err == nil {
+		exp.err = fmt.Errorf("dlsh: ${%s}: bad substitution", body)
+	}
+}
+
+// ${name}, ${#name}, ${name[subscript]} or ${name<op>word}, see paramOp
+// for the operators
+func (exp *expander) braced(body string) {
+	if name, sub, ok := splitSubscript(body); ok {
+		exp.element(body, name, sub)
+		return
+	}
+	if name, sub, ok := splitSubscript(strings.TrimPrefix(body, "#")); ok && body[0] == '#' {
+		values, _ := exp.dlsh.array(name)
+		if sub == "@" || sub == "*" {
+			exp.expansion(strconv.Itoa(len(values)))
+		} else {
+			exp.expansion(strconv.Itoa(len([]rune(exp.index(body, values, sub)))))
+		}
+		return
+	}
+	if len(body) > 1 && body[0] == '#' && isParam(body[1:]) {
+		name := body[1:]
+		if name == "@" || name == "*" {
+			exp.expansion(strconv.Itoa(len(exp.dlsh.Args) - 1))
+			return
+		}
+		value, _ := exp.dlsh.lookup(name)
+		exp.expansion(strconv.Itoa(len([]rune(value))))
+		return
+	}
+
+	end := 0
+	switch {
+	case body == "":
+	case isNameStart(body[0]):
+		for end < len(body) && isNameChar(body[end]) {
+			end++
+		}
+	case body[0] >= '0' && body[0] <= '9':
+		for end < len(body) && body[end] >= '0' && body[end] <= '9' {
+			end++
+		}
+	case strings.IndexByte(specialParams, body[0]) != -1:
+		end = 1
+	}
+	if end == 0 {
+		exp.badSubst(body)
+		return
+	}
+
+	name, rest := body[:end], body[end:]
+	if rest == "" {
+		exp.param(name)
+		return
+	}
+	exp.paramOp(body, name, rest)
+}
+
+// Operators of ${name<op>word}:
+//
+//	:- -  word if name is unset or, with the colon, null
+//	:= =  likewise and name is assigned word
+//	:? ?  likewise and the command fails with word as its message, a
+//	      shell that isn't interactive exits
+//	:+ +  word unless name is unset or null
+//	# ##  value without its shortest or longest prefix matching word
+//	% %%  likewise for the suffix
+//	/ //  the first or every match of a pattern replaced, /pat/string
+//	/# /% a match at the start or end replaced
+//	:     substring, :offset or :offset:length
+func (exp *expander) paramOp(body, name, rest string) {
+	value, set := exp.dlsh.lookup(name)
+	colon := strings.HasPrefix(rest, ":") && len(rest) > 1 && strings.IndexByte("-=?+", rest[1]) != -1
+	if colon {
+		rest = rest[1:]
+		set = set && value != ""
+	}
+
+	op, word := rest[:1], rest[1:]
+	switch op {
+	case "-":
+		if set {
+			exp.param(name)
+		} else {
+			exp.sub(word)
+		}
+	case "=":
+		if set {
+			exp.param(name)
+			return
+		}
+		if !isParam(name) || !isNameStart(name[0]) {
+			if exp.err == nil {
+				exp.err = fmt.Errorf("dlsh: $%s: cannot assign in this way", name)
+			}
+			return
+		}
+		value = exp.dlsh.expandString(word, exp.quoted)
+		if err := exp.dlsh.Vars.Set(name, value); err != nil {
+			if exp.err == nil {
+				exp.err = err
+			}
+			return
+		}
+		exp.expansion(value)
+	case "?":
+		if set {
+			exp.param(name)
+			return
+		}
+		msg := exp.dlsh.expandString(word, exp.quoted)
+		if msg == "" {
+			msg = "parameter null or not set"
+		}
+		if exp.err == nil {
+			exp.err = fmt.Errorf("dlsh: %s: %s", name, msg)
+		}
+		// only the interactive shell outlives the error
+		if !exp.dlsh.Interactive || exp.dlsh.subshell {
+			exp.dlsh.Exit = true
+		}
+	case "+":
+		if set {
+			exp.sub(word)
+		}
+	case "#", "%":
+		longest := strings.HasPrefix(word, op)
+		if longest {
+			word = word[1:]
+		}
+		pat := exp.dlsh.expandPattern(word)
+		if op == "#" {
+			exp.expansion(trimPrefix(value, pat, longest))
+		} else {
+			exp.expansion(trimSuffix(value, pat, longest))
+		}
+	case "/":
+		exp.expansion(exp.replace(value, word))
+	case ":":
+		exp.substring(body, value, word)
+	default:
+		exp.badSubst(body)
+	}
+}
+
+// Expands the operand of a parameter expansion to a single string
+func (dlsh *ExecUnit) expandString(text string, quoted bool) string {
+	exp := dlsh.newExpander(text, false)
+	exp.quoted = quoted
+	exp.text()
+	return exp.sb.String()
+}
+
+// Expands a pattern operand, its quoted parts only match themselves
+func (dlsh *ExecUnit) expandPattern(text string) string {
+	exp := dlsh.newExpander(text, false)
+	exp.text()
+	return exp.pat.String()
+}
+
+func trimPrefix(value, pat string, longest bool) string {
+	runes := []rune(value)
+	for n := range len(runes) + 1 {
+		if longest {
+			n = len(runes) - n
+		}
+		if Match(pat, string(runes[:n])) {
+			return string(runes[n:])
+		}
+	}
+	return value
+}
+
+func trimSuffix(value, pat string, longest bool) string {
+	runes := []rune(value)
+	for n := range len(runes) + 1 {
+		if !longest {
+			n = len(runes) - n
+		}
+		if Match(pat, string(runes[n:])) {
+			return string(runes[:n])
+		}
+	}
+	return value
+}
+
+// ${name/pat/string}, the longest match of pat is replaced
+func (exp *expander) replace(value, word string) string {
+	all := strings.HasPrefix(word, "/")
+	anchor := byte(0)
+	if all {
+		word = word[1:]
+	} else if strings.HasPrefix(word, "#") || strings.HasPrefix(word, "%") {
+		anchor = word[0]
+		word = word[1:]
+	}
+
+	var pat, repl string
+	if sep := indexUnquoted(word, '/'); sep >= 0 {
+		pat = exp.dlsh.expandPattern(word[:sep])
+		repl = exp.dlsh.expandString(word[sep+1:], exp.quoted)
+	} else {
+		pat = exp.dlsh.expandPattern(word)
+	}
+	if pat == "" {
+		return value
+	}
+
+	runes := []rune(value)
+	switch anchor {
+	case '#':
+		for j := len(runes); j >= 0; j-- {
+			if Match(pat, string(runes[:j])) {
+				return repl + string(runes[j:])
+			}
+		}
+		return value
+	case '%':
+		for i := range len(runes) + 1 {
+			if Match(pat, string(runes[i:])) {
+				return string(runes[:i]) + repl
+			}
+		}
+		return value
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(runes); {
+		j := len(runes)
+		for ; j > i && !Match(pat, string(runes[i:j])); j-- {
+		}
+		if j == i {
+			sb.WriteRune(runes[i])
+			i++
+			continue
+		}
+		sb.WriteString(repl)
+		i = j
+		if !all {
+			sb.WriteString(string(runes[i:]))
+			break
+		}
+	}
+	return sb.String()
+}
+
+// ${name:offset[:length]}, a negative offset counts from the end and so
+// does a negative length
+func (exp *expander) substring(body, value, word string) {
+	runes := []rune(value)
+	offWord, lenWord, hasLen := strings.Cut(word, ":")
+	off, err := strconv.Atoi(strings.TrimSpace(exp.dlsh.expandString(offWord, false)))
+	if err != nil {
+		exp.badSubst(body)
+		return
+	}
+	if off < 0 {
+		off = max(len(runes)+off, 0)
+	}
+	off = min(off, len(runes))
+
+	end := len(runes)
+	if hasLen {
+		n, err := strconv.Atoi(strings.TrimSpace(exp.dlsh.expandString(lenWord, false)))
+		if err != nil {
+			exp.badSubst(body)
+			return
+		}
+		if n < 0 {
+			end = len(runes) + n
+		} else {
+			end = off + n
+		}
+		if end < off {
+			if exp.err == nil {
+				exp.err = fmt.Errorf("dlsh: %s: substring expression < 0", lenWord)
+			}
+			return
+		}
+		end = min(end, len(runes))
+	}
+	exp.expansion(string(runes[off:end]))
+}
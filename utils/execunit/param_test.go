@@ -0,0 +1,139 @@
+package execunit
+
+import (
+	"slices"
+	"testing"
+)
+
+// A shell with v=value, file=dir/name.tar.gz, e set but empty, u unset and
+// the positional parameters a "b c"
+func paramShell() *ExecUnit {
+	dlsh := NewExecUnit()
+	dlsh.Vars.Set("v", "value")
+	dlsh.Vars.Set("file", "dir/name.tar.gz")
+	dlsh.Vars.Set("e", "")
+	dlsh.Vars.Unset("u")
+	dlsh.Args = []string{"dlsh", "a", "b c"}
+	dlsh.Status = 3
+	return dlsh
+}
+
+func TestExpandParam(t *testing.T) {
+	tests := []struct {
+		raw, want string
+	}{
+		{"$v", "value"},
+		{"${v}x", "valuex"},
+		{"$vx", ""},
+		{"'$v'", "$v"},
+		{`"$v"`, "value"},
+		{`\$v`, "$v"},
+		{"$?", "3"},
+		{"$#", "2"},
+		{"$1-$2", "a-b c"},
+		{"$3", ""},
+		{"${#v}", "5"},
+		{"${#@}", "2"},
+		{"${u-def}", "def"},
+		{"${e-def}", ""},
+		{"${e:-def}", "def"},
+		{"${v:-def}", "value"},
+		{"${u:-$v}", "value"},
+		{"${u+alt}", ""},
+		{"${e+alt}", "alt"},
+		{"${e:+alt}", ""},
+		{"${v:+alt}", "alt"},
+		{"${file#*/}", "name.tar.gz"},
+		{"${file##*.}", "gz"},
+		{"${file%.*}", "dir/name.tar"},
+		{"${file%%.*}", "dir/name"},
+		{"${file#x}", "dir/name.tar.gz"},
+		{"${v/l/L}", "vaLue"},
+		{"${file//a/A}", "dir/nAme.tAr.gz"},
+		{"${v/#va/VA}", "VAlue"},
+		{"${v/%ue/UE}", "valUE"},
+		{"${v:1}", "alue"},
+		{"${v:1:3}", "alu"},
+		{"${v: -2}", "ue"},
+		{"${v[0]}", "value"},
+		{"${v[1]}", ""},
+	}
+	dlsh := paramShell()
+	for _, test := range tests {
+		got, err := dlsh.Expand(&Word{Raw: test.raw})
+		if err != nil {
+			t.Errorf("%s: %v", test.raw, err)
+		} else if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.raw, got, test.want)
+		}
+	}
+}
+
+func TestExpandAssign(t *testing.T) {
+	dlsh := paramShell()
+	got, err := dlsh.Expand(&Word{Raw: "${u:=new}"})
+	if err != nil || got != "new" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+	if value, _ := dlsh.Vars.Get("u"); value != "new" {
+		t.Errorf("u is %q after ${u:=new}", value)
+	}
+	if _, err := dlsh.Expand(&Word{Raw: "${3:=x}"}); err == nil {
+		t.Error("${3:=x} assigned a positional parameter")
+	}
+}
+
+func TestExpandError(t *testing.T) {
+	tests := []struct {
+		raw  string
+		exit bool
+	}{
+		{"${u:?}", true},
+		{"${e:?msg}", true},
+		{"${u?msg}", true},
+		{"${v!}", false},
+		{"${v[x]}", false},
+	}
+	for _, test := range tests {
+		dlsh := paramShell()
+		if _, err := dlsh.Expand(&Word{Raw: test.raw}); err == nil {
+			t.Errorf("%s: no error", test.raw)
+		}
+		if dlsh.Exit != test.exit {
+			t.Errorf("%s: Exit is %v", test.raw, dlsh.Exit)
+		}
+	}
+
+	// the interactive shell outlives ${name:?}
+	dlsh := paramShell()
+	dlsh.Interactive = true
+	dlsh.Expand(&Word{Raw: "${u:?}"})
+	if dlsh.Exit {
+		t.Error("${u:?} exits an interactive shell")
+	}
+}
+
+func TestExpandFields(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want []string
+	}{
+		{"$@", []string{"a", "b", "c"}},
+		{`"$@"`, []string{"a", "b c"}},
+		{`"$*"`, []string{"a b c"}},
+		{`x"$@"y`, []string{"xa", "b cy"}},
+		{"$u", nil},
+		{`"$u"`, []string{""}},
+		{"$e$u", nil},
+	}
+	dlsh := paramShell()
+	dlsh.Vars.Unset("IFS")
+	for _, test := range tests {
+		got, err := dlsh.ExpandFields(&Word{Raw: test.raw})
+		if err != nil {
+			t.Errorf("%s: %v", test.raw, err)
+		} else if !slices.Equal(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.raw, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,623 @@
+package execunit
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Grammar:
+//
+//	sequence  := linebreak [and_or (separator and_or)*] [separator]
+//	separator := ';' | '&' | NEWLINE
+//	and_or    := pipeline (('&&' | '||') linebreak pipeline)*
+//	pipeline  := ['!'] command ('|' linebreak command)*
+//	command   := compound | funcdef | simple
+//	compound  := (brace | subshell | if | while | for | case) redirect*
+//	brace     := '{' sequence '}'
+//	subshell  := '(' sequence ')'
+//	if        := 'if' sequence 'then' sequence
+//	             ('elif' sequence 'then' sequence)* ['else' sequence] 'fi'
+//	while     := ('while' | 'until') sequence 'do' sequence 'done'
+//	for       := 'for' NAME linebreak ['in' WORD* (';' | NEWLINE)] [';']
+//	             linebreak 'do' sequence 'done'
+//	case      := 'case' WORD linebreak 'in' linebreak
+//	             (['('] WORD ('|' WORD)* ')' sequence ';;' linebreak)* 'esac'
+//	funcdef   := NAME '(' ')' linebreak compound
+//	simple    := (ASSIGNMENT | redirect)* (WORD | redirect)*
+//	redirect  := [IO_NUMBER]REDIRECTION WORD
+//
+// Reserved words such as `if` and `{` are WORDs only recognised in command
+// position, `in` also right after the name of a for or the word of a case.
+// The last item of a case may leave out its `;;`. An ASSIGNMENT is a WORD
+// of the form NAME=value. A WORD may hold command substitutions,
+// $(sequence) or `sequence`, which are parsed again when the word is
+// expanded. The command name is replaced by its alias, if it has one, and
+// lexed again.
+type Parser struct {
+	lex *Lexer
+	tok Token
+	// offset where the text of an alias ending in a blank ends, the word
+	// there is subject to alias expansion as well. -1 if there is none.
+	aliasNext int
+}
+
+func NewParser(src string) *Parser {
+	parser := new(Parser)
+	parser.lex = NewLexer(src)
+	parser.aliasNext = -1
+	return parser
+}
+
+// Parses src without alias expansion
+func Parse(src string) (*Sequence, error) {
+	return ParseAliases(src, nil)
+}
+
+func ParseAliases(src string, aliases map[string]string) (*Sequence, error) {
+	parser := NewParser(src)
+	parser.lex.aliases = aliases
+	if err := parser.next(); err != nil {
+		return nil, err
+	}
+	return parser.sequence()
+}
+
+// Parses src expanding the shell's aliases
+func (dlsh *ExecUnit) Parse(src string) (*Sequence, error) {
+	return ParseAliases(src, dlsh.Aliases)
+}
+
+func (p *Parser) next() error {
+	var err error
+	p.tok, err = p.lex.Next()
+	return err
+}
+
+func (p *Parser) isOp(ops ...string) bool {
+	return p.tok.Type == OPERATOR && slices.Contains(ops, p.tok.Val)
+}
+
+func (p *Parser) unexpected() error {
+	if p.tok.Type == EOF {
+		return p.lex.errorf(p.tok.Pos, true, "unexpected end of input")
+	}
+	return p.lex.errorf(p.tok.Pos, false, "unexpected token `%s'", p.tok)
+}
+
+func (p *Parser) linebreak() error {
+	for p.tok.Type == NEWLINE {
+		if err := p.next(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The sequence ends at the end of input or before one of the stop
+// operators, such as the `)` closing a command substitution
+func (p *Parser) sequence(stop ...string) (*Sequence, error) {
+	seq := &Sequence{Pos: p.tok.Pos}
+	for {
+		if err := p.linebreak(); err != nil {
+			return nil, err
+		}
+		if p.tok.Type == EOF || p.isStop(stop...) {
+			return seq, nil
+		}
+
+		node, err := p.andOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.isOp("&") {
+			node = &Background{Pos: p.tok.Pos, Node: node}
+		}
+		seq.Items = append(seq.Items, node)
+
+		if p.isOp(";", "&") || p.tok.Type == NEWLINE {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+		} else if p.tok.Type != EOF && !p.isStop(stop...) {
+			return nil, p.unexpected()
+		}
+	}
+}
+
+func (p *Parser) andOr() (Node, error) {
+	left, err := p.pipeline()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("&&", "||") {
+		ao := &AndOr{Pos: p.tok.Pos, Left: left, Op: p.tok.Val}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if err := p.linebreak(); err != nil {
+			return nil, err
+		}
+		if ao.Right, err = p.pipeline(); err != nil {
+			return nil, err
+		}
+		left = ao
+	}
+	return left, nil
+}
+
+func (p *Parser) pipeline() (Node, error) {
+	pipeline := &Pipeline{Pos: p.tok.Pos}
+	if p.isReserved("!") {
+		pipeline.Negate = true
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		cmd, err := p.command()
+		if err != nil {
+			return nil, err
+		}
+		pipeline.Cmds = append(pipeline.Cmds, cmd)
+
+		if !p.isOp("|") {
+			return pipeline, nil
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if err := p.linebreak(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Words reserved in command position, where they aren't commands
+var reservedWords = []string{
+	"!", "{", "}", "if", "then", "elif", "else", "fi", "while", "until", "do",
+	"done", "for", "in", "case", "esac",
+}
+
+func (p *Parser) isReserved(words ...string) bool {
+	return p.tok.Type == WORD && slices.Contains(words, p.tok.Val)
+}
+
+// The operators or reserved words ending a sequence
+func (p *Parser) isStop(stop ...string) bool {
+	return p.isOp(stop...) || p.isReserved(stop...)
+}
+
+// The token after the current one, the lexer is left where it was
+func (p *Parser) peek() (Token, error) {
+	saved := *p.lex
+	tok, err := p.lex.Next()
+	*p.lex = saved
+	return tok, err
+}
+
+// Replaces the current word by the text of its alias, if it has one, and
+// reads the first token of that text
+func (p *Parser) alias() (bool, error) {
+	if IsAssignment(p.tok.Val) {
+		return false, nil
+	}
+	value, ok := p.lex.alias(p.tok)
+	if !ok {
+		return false, nil
+	}
+	if strings.HasSuffix(value, " ") || strings.HasSuffix(value, "\t") {
+		p.aliasNext = p.tok.Pos.Offset + len(value)
+	}
+	return true, p.next()
+}
+
+func (p *Parser) command() (Node, error) {
+	for p.tok.Type == WORD {
+		expanded, err := p.alias()
+		if err != nil {
+			return nil, err
+		}
+		if !expanded {
+			break
+		}
+	}
+	if node, err := p.compound(); node != nil || err != nil {
+		return node, err
+	}
+	if p.isReserved(reservedWords...) {
+		return nil, p.unexpected()
+	}
+	if p.tok.Type == WORD && IsAliasName(p.tok.Val) {
+		if next, err := p.peek(); err == nil && next.Type == OPERATOR && next.Val == "(" {
+			return p.funcDef()
+		}
+	}
+	return p.simpleCommand()
+}
+
+// A compound command, nil if the current token doesn't start one
+func (p *Parser) compound() (Node, error) {
+	switch {
+	case p.isReserved("{"):
+		return p.braceGroup()
+	case p.isOp("("):
+		return p.subshell()
+	case p.isReserved("if"):
+		return p.ifClause()
+	case p.isReserved("while", "until"):
+		return p.whileClause()
+	case p.isReserved("for"):
+		return p.forClause()
+	case p.isReserved("case"):
+		return p.caseClause()
+	}
+	return nil, nil
+}
+
+// Reads the reserved word expected next
+func (p *Parser) expect(word string) error {
+	if !p.isReserved(word) {
+		return p.unexpected()
+	}
+	return p.next()
+}
+
+// A sequence that can't be empty, as the condition or body of a compound
+// command
+func (p *Parser) list(stop ...string) (*Sequence, error) {
+	seq, err := p.sequence(stop...)
+	if err != nil {
+		return nil, err
+	}
+	if len(seq.Items) == 0 {
+		return nil, p.unexpected()
+	}
+	return seq, nil
+}
+
+func (p *Parser) ifClause() (Node, error) {
+	clause := &IfClause{Pos: p.tok.Pos}
+	for len(clause.Branches) == 0 || p.isReserved("elif") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		branch := new(Branch)
+		var err error
+		if branch.Cond, err = p.list("then"); err != nil {
+			return nil, err
+		}
+		if err := p.expect("then"); err != nil {
+			return nil, err
+		}
+		if branch.Body, err = p.list("elif", "else", "fi"); err != nil {
+			return nil, err
+		}
+		clause.Branches = append(clause.Branches, branch)
+	}
+	if p.isReserved("else") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		var err error
+		if clause.Else, err = p.list("fi"); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expect("fi"); err != nil {
+		return nil, err
+	}
+	var err error
+	if clause.Redirs, err = p.redirects(); err != nil {
+		return nil, err
+	}
+	return clause, nil
+}
+
+// The do ... done body of a loop
+func (p *Parser) loopBody() (*Sequence, error) {
+	if err := p.expect("do"); err != nil {
+		return nil, err
+	}
+	body, err := p.list("done")
+	if err != nil {
+		return nil, err
+	}
+	return body, p.expect("done")
+}
+
+func (p *Parser) whileClause() (Node, error) {
+	clause := &WhileClause{Pos: p.tok.Pos, Until: p.tok.Val == "until"}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	var err error
+	if clause.Cond, err = p.list("do"); err != nil {
+		return nil, err
+	}
+	if clause.Body, err = p.loopBody(); err != nil {
+		return nil, err
+	}
+	if clause.Redirs, err = p.redirects(); err != nil {
+		return nil, err
+	}
+	return clause, nil
+}
+
+func (p *Parser) forClause() (Node, error) {
+	clause := &ForClause{Pos: p.tok.Pos}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if p.tok.Type != WORD || !isParam(p.tok.Val) || !isNameStart(p.tok.Val[0]) {
+		return nil, p.unexpected()
+	}
+	clause.Name = p.tok.Val
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if err := p.linebreak(); err != nil {
+		return nil, err
+	}
+	if p.isReserved("in") {
+		clause.In = true
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		for p.tok.Type == WORD {
+			clause.Words = append(clause.Words, &Word{Pos: p.tok.Pos, Raw: p.tok.Val})
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+		}
+		if !p.isOp(";") && p.tok.Type != NEWLINE {
+			return nil, p.unexpected()
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	} else if p.isOp(";") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.linebreak(); err != nil {
+		return nil, err
+	}
+	var err error
+	if clause.Body, err = p.loopBody(); err != nil {
+		return nil, err
+	}
+	if clause.Redirs, err = p.redirects(); err != nil {
+		return nil, err
+	}
+	return clause, nil
+}
+
+func (p *Parser) caseClause() (Node, error) {
+	clause := &CaseClause{Pos: p.tok.Pos}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if p.tok.Type != WORD {
+		return nil, p.unexpected()
+	}
+	clause.Word = &Word{Pos: p.tok.Pos, Raw: p.tok.Val}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if err := p.linebreak(); err != nil {
+		return nil, err
+	}
+	if err := p.expect("in"); err != nil {
+		return nil, err
+	}
+	if err := p.linebreak(); err != nil {
+		return nil, err
+	}
+
+	for !p.isReserved("esac") {
+		if p.isOp("(") {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+		}
+		item := new(CaseItem)
+		for {
+			if p.tok.Type != WORD {
+				return nil, p.unexpected()
+			}
+			item.Patterns = append(item.Patterns, &Word{Pos: p.tok.Pos, Raw: p.tok.Val})
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			if !p.isOp("|") {
+				break
+			}
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+		}
+		if !p.isOp(")") {
+			return nil, p.unexpected()
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		var err error
+		if item.Body, err = p.sequence(";;", "esac"); err != nil {
+			return nil, err
+		}
+		clause.Items = append(clause.Items, item)
+		if !p.isOp(";;") {
+			break
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if err := p.linebreak(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expect("esac"); err != nil {
+		return nil, err
+	}
+	var err error
+	if clause.Redirs, err = p.redirects(); err != nil {
+		return nil, err
+	}
+	return clause, nil
+}
+
+// Redirections after a compound command
+func (p *Parser) redirects() ([]*Redirect, error) {
+	var redirs []*Redirect
+	for p.tok.Type == REDIRECTION {
+		redir, err := p.redirect()
+		if err != nil {
+			return nil, err
+		}
+		redirs = append(redirs, redir)
+	}
+	return redirs, nil
+}
+
+func (p *Parser) braceGroup() (Node, error) {
+	group := &BraceGroup{Pos: p.tok.Pos}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	body, err := p.list("}")
+	if err != nil {
+		return nil, err
+	}
+	group.Body = body
+	if err := p.expect("}"); err != nil {
+		return nil, err
+	}
+	if group.Redirs, err = p.redirects(); err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+func (p *Parser) subshell() (Node, error) {
+	sub := &Subshell{Pos: p.tok.Pos}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	body, err := p.list(")")
+	if err != nil {
+		return nil, err
+	}
+	sub.Body = body
+	if !p.isOp(")") {
+		return nil, p.unexpected()
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if sub.Redirs, err = p.redirects(); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+func (p *Parser) funcDef() (Node, error) {
+	def := &FuncDef{Pos: p.tok.Pos, Name: p.tok.Val}
+	for range 2 {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	if !p.isOp(")") {
+		return nil, p.unexpected()
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if err := p.linebreak(); err != nil {
+		return nil, err
+	}
+	body, err := p.compound()
+	if err != nil {
+		return nil, err
+	}
+	if body == nil {
+		return nil, p.unexpected()
+	}
+	def.Body = body
+	return def, nil
+}
+
+// [IO_NUMBER]REDIRECTION WORD, the token after the target is read
+func (p *Parser) redirect() (*Redirect, error) {
+	redir := &Redirect{Pos: p.tok.Pos, Fd: -1, Op: p.tok.Val}
+	if digits := strings.IndexFunc(p.tok.Val, func(r rune) bool {
+		return r < '0' || r > '9'
+	}); digits > 0 {
+		redir.Fd, _ = strconv.Atoi(p.tok.Val[:digits])
+		redir.Op = p.tok.Val[digits:]
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if p.tok.Type != WORD {
+		return nil, p.unexpected()
+	}
+	redir.Target = &Word{Pos: p.tok.Pos, Raw: p.tok.Val}
+	if redir.Op == "<<" || redir.Op == "<<-" {
+		redir.Doc = &HereDoc{
+			Pos:       redir.Pos,
+			Delim:     Unquote(p.tok.Val),
+			StripTabs: redir.Op == "<<-",
+			Quoted:    strings.ContainsAny(p.tok.Val, "'\"\\"),
+		}
+		p.lex.docs = append(p.lex.docs, redir.Doc)
+	}
+	return redir, p.next()
+}
+
+func (p *Parser) simpleCommand() (*SimpleCommand, error) {
+	cmd := &SimpleCommand{Pos: p.tok.Pos}
+	for {
+		switch p.tok.Type {
+		case WORD:
+			expand := len(cmd.Words) == 0
+			if p.aliasNext >= 0 && p.tok.Pos.Offset >= p.aliasNext {
+				expand = true
+				p.aliasNext = -1
+			}
+			if expand {
+				expanded, err := p.alias()
+				if err != nil {
+					return nil, err
+				}
+				if expanded {
+					continue
+				}
+			}
+			word := &Word{Pos: p.tok.Pos, Raw: p.tok.Val}
+			if len(cmd.Words) == 0 && IsAssignment(word.Raw) {
+				cmd.Assigns = append(cmd.Assigns, word)
+			} else {
+				cmd.Words = append(cmd.Words, word)
+			}
+		case REDIRECTION:
+			redir, err := p.redirect()
+			if err != nil {
+				return nil, err
+			}
+			cmd.Redirs = append(cmd.Redirs, redir)
+			continue
+		default:
+			if len(cmd.Assigns) == 0 && len(cmd.Words) == 0 && len(cmd.Redirs) == 0 {
+				return nil, p.unexpected()
+			}
+			return cmd, nil
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+}
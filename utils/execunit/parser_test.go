@@ -0,0 +1,106 @@
+package execunit
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		src, want string
+	}{
+		{"a  b|c", "a b | c"},
+		{"! a | b", "! a | b"},
+		{"a && b || c &", "a && b || c &"},
+		{"a &\nb", "a & b"},
+		{"a\n\nb;c", "a; b; c"},
+		{"x=1 y=$(a) cmd >>out", "x=1 y=$(a) cmd >>out"},
+		{"cat <<EOF 2>&1\nbody\nEOF\n", "cat <<EOF 2>&1"},
+		{"{ a; b; } >f", "{ a; b; } >f"},
+		{"(cd /; pwd) 2>/dev/null", "( cd /; pwd ) 2>/dev/null"},
+		{"if a; then b; elif c; then d; else e; fi > f", "if a; then b; elif c; then d; else e; fi >f"},
+		{"while :; do :; done", "while :; do :; done"},
+		{"until a\ndo b\ndone", "until a; do b; done"},
+		{"for i in 1 2; do echo $i; done", "for i in 1 2; do echo $i; done"},
+		{"for i\ndo echo; done", "for i; do echo; done"},
+		{"case $x in a|b) echo;; (c) ;; esac", "case $x in a | b) echo;; c) ;; esac"},
+		{"case x in\na) b\nesac", "case x in a) b;; esac"},
+		{"f() { echo; }", "f() { echo; }"},
+		{"f() (cd /)", "f() ( cd / )"},
+		{"if a\nthen\n  b &\nfi", "if a; then b & fi"},
+	}
+	for _, test := range tests {
+		seq, err := ParseAliases(test.src, nil)
+		if err != nil {
+			t.Errorf("%q: %v", test.src, err)
+			continue
+		}
+		if got := seq.String(); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.src, got, test.want)
+		}
+	}
+}
+
+func TestParseAliases(t *testing.T) {
+	aliases := map[string]string{"ll": "ls -l", "e": "echo ", "x": "y"}
+	tests := []struct {
+		src, want string
+	}{
+		{"ll a", "ls -l a"},
+		{"echo ll", "echo ll"},
+		{"e ll", "echo ls -l"},
+		{"e x", "echo y"},
+		{"a; ll", "a; ls -l"},
+		{"'ll'", "'ll'"},
+	}
+	for _, test := range tests {
+		seq, err := ParseAliases(test.src, aliases)
+		if err != nil {
+			t.Errorf("%q: %v", test.src, err)
+			continue
+		}
+		if got := seq.String(); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.src, got, test.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		src        string
+		incomplete bool
+	}{
+		{"a |", true},
+		{"a &&", true},
+		{"if a; then", true},
+		{"while a; do b", true},
+		{"(a", true},
+		{"{ a", true},
+		{"case x in", true},
+		{"f()", true},
+		{"cat <<E\nx", true},
+		{"echo )", false},
+		{"fi", false},
+		{"a ;; b", false},
+		{"for 1 in", false},
+		{"if; then a; fi", false},
+		{"{ }", false},
+		{"()", false},
+	}
+	for _, test := range tests {
+		_, err := ParseAliases(test.src, nil)
+		if err == nil {
+			t.Errorf("%q: no error", test.src)
+		} else if IsIncomplete(err) != test.incomplete {
+			t.Errorf("%q: %v, incomplete is %v", test.src, err, IsIncomplete(err))
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	seq, err := ParseAliases("f() { if a; then b; c & fi; (d); }", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "f () \n{\n    if a; then\n        b;\n        c &\n    fi;\n    (\n        d\n    )\n}"
+	if got := Format(seq.Items[0]); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
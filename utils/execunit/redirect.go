@@ -0,0 +1,161 @@
+package execunit
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// A redirection of a simple command with its target already expanded
+type Redirection struct {
+	Fd     int
+	Op     string
+	Target string
+}
+
+func DefaultFd(op string) int {
+	switch op {
+	case "<", "<&", "<>", "<<", "<<-", "<<<":
+		return 0
+	}
+	return 1
+}
+
+// The file open at fd for the command, nil if fd is closed
+func (ins *Instruction) File(fd int) *os.File {
+	switch fd {
+	case 0:
+		return ins.R
+	case 1:
+		return ins.W
+	case 2:
+		return ins.E
+	}
+	if fd-3 < len(ins.Cmd.ExtraFiles) {
+		return ins.Cmd.ExtraFiles[fd-3]
+	}
+	return nil
+}
+
+// Fds above 2 are passed through exec.Cmd.ExtraFiles, a nil entry is closed
+// in the child. The standard streams cannot be closed that way, closing them
+// points them at /dev/null instead.
+func (ins *Instruction) SetFile(fd int, fp *os.File) {
+	if fp == nil && fd <= 2 {
+		fp, _ = os.Open(os.DevNull)
+		ins.files = append(ins.files, fp)
+	}
+	switch fd {
+	case 0:
+		ins.R = fp
+		ins.Cmd.Stdin = fp
+	case 1:
+		ins.W = fp
+		ins.Cmd.Stdout = fp
+	case 2:
+		ins.E = fp
+		ins.Cmd.Stderr = fp
+	default:
+		for len(ins.Cmd.ExtraFiles) <= fd-3 {
+			ins.Cmd.ExtraFiles = append(ins.Cmd.ExtraFiles, nil)
+		}
+		ins.Cmd.ExtraFiles[fd-3] = fp
+	}
+}
+
+// Opens target relative to the directory the command runs in
+func (ins *Instruction) open(target string, flag int) (*os.File, error) {
+	fp, err := openIn(ins.Cmd.Dir, target, flag)
+	if err != nil {
+		return nil, err
+	}
+	ins.files = append(ins.files, fp)
+	return fp, nil
+}
+
+// Feeds text to the command through a pipe. It is written from a goroutine
+// so that a body larger than the pipe buffer can't block the shell.
+func (ins *Instruction) hereDoc(text string) (*os.File, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	ins.files = append(ins.files, r)
+	go func() {
+		w.WriteString(text)
+		w.Close()
+	}()
+	return r, nil
+}
+
+// Applies a single redirection, for here-documents and here-strings the
+// target is the text fed to the command:
+//
+//	[n]<file  [n]>file  [n]>|file  [n]>>file  [n]<>file
+//	[n]<&m  [n]>&m  [n]<&-  [n]>&-  &>file  &>>file  >&file
+//	[n]<<delim  [n]<<-delim  [n]<<<word
+func (ins *Instruction) Redirect(redir *Redirection) error {
+	fd, op, target := redir.Fd, redir.Op, redir.Target
+	if fd < 0 {
+		fd = DefaultFd(op)
+	}
+
+	var fp *os.File
+	var err error
+	switch op {
+	case "<":
+		fp, err = ins.open(target, os.O_RDONLY)
+	case ">", ">|":
+		fp, err = ins.open(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	case ">>":
+		fp, err = ins.open(target, os.O_WRONLY|os.O_CREATE|os.O_APPEND)
+	case "<>":
+		fp, err = ins.open(target, os.O_RDWR|os.O_CREATE)
+	case "<<", "<<-", "<<<":
+		fp, err = ins.hereDoc(target)
+	case "<&", ">&":
+		if target == "-" {
+			ins.SetFile(fd, nil)
+			return nil
+		}
+		src, convErr := strconv.Atoi(target)
+		if convErr != nil {
+			if op == "<&" || redir.Fd >= 0 {
+				return fmt.Errorf("%s: ambiguous redirect", target)
+			}
+			// >&file is &>file
+			return ins.Redirect(&Redirection{Fd: -1, Op: "&>", Target: target})
+		}
+		if fp = ins.File(src); fp == nil {
+			return fmt.Errorf("%d: Bad file descriptor", src)
+		}
+	case "&>", "&>>":
+		flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if op == "&>>" {
+			flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+		if fp, err = ins.open(target, flag); err != nil {
+			return err
+		}
+		ins.SetFile(1, fp)
+		ins.SetFile(2, fp)
+		return nil
+	default:
+		return fmt.Errorf("unsupported redirection %s", op)
+	}
+	if err != nil {
+		return err
+	}
+	ins.SetFile(fd, fp)
+	return nil
+}
+
+// Applies the command's redirections in order, stops at the first failure
+func (ins *Instruction) ApplyRedirects() error {
+	for _, redir := range ins.Redirs {
+		if err := ins.Redirect(redir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package execunit
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedirect(t *testing.T) {
+	testShell(t, []shellTest{
+		{"echo a >f; echo b >>f; cat f", "a\nb\n", "", 0},
+		{"echo a >f; echo b >f; cat <f", "b\n", "", 0},
+		{"ls /nonexistent 2>f; wc -l <f", "1\n", "", 0},
+		{"ls /nonexistent 2>&1 >/dev/null | wc -l", "1\n", "", 0},
+		{"ls /nonexistent >/dev/null 2>&1; echo $?", "2\n", "", 0},
+		{"{ echo a; ls /nonexistent; } &>f; wc -l <f", "2\n", "", 0},
+		{"echo a 1>&2", "", "a\n", 0},
+		{"echo a >&2", "", "a\n", 0},
+		{"echo abc >f; cat 0<>f", "abc\n", "", 0},
+		{"echo a >f; echo c >|f; cat f", "c\n", "", 0},
+		{"echo a 3>f >&3; cat f", "a\n", "", 0},
+		{"sh -c 'echo a >&4' 4>f; cat f", "a\n", "", 0},
+		{"sh -c 'cat <&5' 5<f", "", "open f: no such file or directory\n", 1},
+		{"echo a >f; sh -c 'cat <&5' 5<f", "a\n", "", 0},
+		{"echo a >nodir/f", "", "open nodir/f: no such file or directory\n", 1},
+		{"x=f; echo a >$x; cat f", "a\n", "", 0},
+		{"{ echo a; echo b; } >f; cat f", "a\nb\n", "", 0},
+		{"for i in 1 2; do echo $i; done >f; cat f", "1\n2\n", "", 0},
+		{"echo a >f; cat <&3 3<f", "", "3: Bad file descriptor\n", 1},
+		{"echo a >&-; echo $?", "1\n", "echo: write error: write /dev/null: bad file descriptor\n", 0},
+		{"echo a 3>&1 >/dev/null >&3", "a\n", "", 0},
+		{"echo a >&9", "", "9: Bad file descriptor\n", 1},
+		{"echo a >&x", "", "", 0},
+		{"echo a &>>f; echo b &>>f; cat f", "a\nb\n", "", 0},
+		{"mkdir d; (cd d; echo a >f); cat d/f", "a\n", "", 0},
+	})
+}
+
+func TestHereDoc(t *testing.T) {
+	testShell(t, []shellTest{
+		{"cat <<EOF\na\n  b\nEOF\necho c", "a\n  b\nc\n", "", 0},
+		{"x=1; cat <<EOF\n$x $(echo 2) `echo 3`\nEOF", "1 2 3\n", "", 0},
+		{"x=1; cat <<'EOF'\n$x $(echo 2)\nEOF", "$x $(echo 2)\n", "", 0},
+		{"x=1; cat <<\"EOF\"\n$x\nEOF", "$x\n", "", 0},
+		{"cat <<-EOF\n\ta\n\t\tb\n\tEOF", "a\nb\n", "", 0},
+		{"cat <<EOF\nEOF", "", "", 0},
+		{"cat <<A; cat <<B\na\nA\nb\nB", "a\nb\n", "", 0},
+		{"cat <<EOF | wc -l\na\nb\nEOF", "2\n", "", 0},
+		{"while true; do cat; break; done <<EOF\na\nEOF", "a\n", "", 0},
+		{"f() { cat; }; f <<EOF\na\nEOF", "a\n", "", 0},
+		{"cat 3<<EOF <&3\na\nEOF", "a\n", "", 0},
+		{"wc -c <<EOF\n" + strings.Repeat("a", 100000) + "\nEOF", "100001\n", "", 0},
+		{"cat <<<a", "a\n", "", 0},
+		{"x='a  b'; cat <<<$x; cat <<<\"$x\"", "a  b\na  b\n", "", 0},
+		{"wc -c <<<''", "1\n", "", 0},
+		{"tr a b <<<aaa", "bbb\n", "", 0},
+	})
+}
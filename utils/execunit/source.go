@@ -0,0 +1,134 @@
+package execunit
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The rc file, $XDG_CONFIG_HOME/dlsh/dlshrc
+func RcPath() string {
+	config := os.Getenv("XDG_CONFIG_HOME")
+	if config == "" {
+		config = filepath.Join(os.Getenv("HOME"), ".config")
+	}
+	return filepath.Join(config, "dlsh", "dlshrc")
+}
+
+// Runs the commands of a file in the shell, a `return` outside of any
+// function ends it
+func (dlsh *ExecUnit) Source(path string) error {
+	fp, err := openIn(dlsh.Dir, path, os.O_RDONLY)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	dlsh.SourceDepth++
+	dlsh.RunScript(bufio.NewReader(fp), path)
+	dlsh.SourceDepth--
+	dlsh.Return = false
+	return nil
+}
+
+// Reads up to and including the next newline one byte at a time, so that
+// commands sharing r, as with a script read from stdin, find their input
+// right after the line
+func readLine(r io.Reader) (string, error) {
+	var line []byte
+	buf := make([]byte, 1)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			line = append(line, buf[0])
+			if buf[0] == '\n' {
+				return string(line), nil
+			}
+		}
+		if err != nil {
+			return string(line), err
+		}
+	}
+}
+
+// Runs the commands read from r one complete command at a time, so that an
+// alias defined on one line applies to the lines after it. A syntax error
+// ends the script, it is reported along with name.
+func (dlsh *ExecUnit) RunScript(r io.Reader, name string) {
+	var src string
+	// lines of the commands run so far
+	lines := 0
+	for eof := false; !eof; {
+		line, err := readLine(r)
+		eof = err != nil
+		src += line
+		if src == "" {
+			continue
+		}
+		prog, err := dlsh.Parse(src)
+		continued := strings.HasSuffix(src, "\\\n") &&
+			(len(src)-len(strings.TrimRight(src[:len(src)-1], `\`)))%2 == 0
+		if (IsIncomplete(err) || continued) && !eof {
+			continue
+		}
+		if err != nil {
+			var serr *SyntaxError
+			if errors.As(err, &serr) {
+				serr.Pos.Line += lines
+			}
+			fmt.Fprintf(dlsh.Stderr, "%s: %s\n", name, strings.TrimPrefix(err.Error(), "dlsh: "))
+			dlsh.Status = 2
+			return
+		}
+		lines += strings.Count(src, "\n")
+		src = ""
+		dlsh.Exec(prog)
+		if dlsh.Exit || dlsh.Return || dlsh.interrupt.Load() {
+			return
+		}
+	}
+}
+
+// The first file called name in the directories of PATH, it doesn't need to
+// be executable. Otherwise name is left relative to the working directory.
+func (dlsh *ExecUnit) sourcePath(name string) string {
+	path, _ := dlsh.Vars.Get("PATH")
+	for _, dir := range filepath.SplitList(path) {
+		found := filepath.Join(dir, name)
+		if info, err := os.Stat(found); err == nil && info.Mode().IsRegular() {
+			return found
+		}
+	}
+	return name
+}
+
+// source file [arg ...], also `.`. A file name without a slash is looked up
+// in PATH first. Arguments replace the positional parameters while it runs.
+func (dlsh *ExecUnit) BuiltinSource(args []string, stdin, stdout, stderr *os.File) int {
+	if len(args) < 2 {
+		fmt.Fprintf(stderr, "%s: filename argument required\n", args[0])
+		return 2
+	}
+	path := args[1]
+	if !strings.Contains(path, "/") {
+		path = dlsh.sourcePath(path)
+	}
+	if len(args) > 2 {
+		saved := dlsh.Args
+		dlsh.Args = append([]string{saved[0]}, args[2:]...)
+		defer func() { dlsh.Args = saved }()
+	}
+
+	var err error
+	dlsh.withFiles(stdin, stdout, stderr, func() {
+		err = dlsh.Source(path)
+	})
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %s: %s\n", args[0], args[1], pathError(err))
+		return 1
+	}
+	return dlsh.Status
+}
@@ -0,0 +1,69 @@
+package execunit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Scripts and -c strings run with RunScript the way main does, with args
+// as $0, $1 ...
+func TestRunScript(t *testing.T) {
+	tests := []struct {
+		args []string
+		shellTest
+	}{
+		{nil, shellTest{"echo a\necho b", "a\nb\n", "", 0}},
+		{nil, shellTest{"echo a; false", "a\n", "", 1}},
+		{nil, shellTest{"exit 3; echo no", "", "", 3}},
+		{nil, shellTest{"false; exit", "", "", 1}},
+		{nil, shellTest{"#!/usr/bin/env dlsh\n# comment\necho a # b", "a\n", "", 0}},
+		{nil, shellTest{"echo a \\\nb", "a b\n", "", 0}},
+		{nil, shellTest{"if true\nthen echo a\nfi\necho b", "a\nb\n", "", 0}},
+		{nil, shellTest{"alias x='echo a'\nx", "a\n", "", 0}},
+		{nil, shellTest{"echo a\necho 'b\necho c", "a\n", "dlsh: syntax error at 2:6: unterminated single quote\n", 2}},
+		{nil, shellTest{"echo a\nfi\necho b", "a\n", "dlsh: syntax error at 2:1: unexpected token `fi'\n", 2}},
+		{nil, shellTest{"echo $0", "dlsh\n", "", 0}},
+		{[]string{"script", "a", "b c"}, shellTest{"echo $0 $# $1; echo \"$@\"", "script 2 a\na b c\n", "", 0}},
+		{[]string{"script", "a"}, shellTest{"set -- b c; echo $0 $@", "script b c\n", "", 0}},
+	}
+	for _, test := range tests {
+		dlsh := newShell(t)
+		if test.args != nil {
+			dlsh.Args = test.args
+		}
+		stdout, stderr, status := runShell(t, dlsh, test.src)
+		if stdout != test.stdout || stderr != test.stderr || status != test.status {
+			t.Errorf("%q: got %q, %q, %d, want %q, %q, %d", test.src,
+				stdout, stderr, status, test.stdout, test.stderr, test.status)
+		}
+	}
+}
+
+func TestSource(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, src string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("vars", "x=1\nf() { echo f$1; }\n")
+	write("args", "echo $# $1\n")
+	write("ret", "echo a\nreturn 3\necho no\n")
+	write("exit", "exit 4\necho no\n")
+	write("bad", "echo a\nfi\n")
+	vars := filepath.Join(dir, "vars")
+	testShell(t, []shellTest{
+		{"source " + vars + "; echo $x; f a", "1\nfa\n", "", 0},
+		{". " + vars + "; echo $x", "1\n", "", 0},
+		{"PATH=" + dir + "; . vars; echo $x", "1\n", "", 0},
+		{"set -- a b; . " + dir + "/args; . " + dir + "/args c; echo $1", "2 a\n1 c\na\n", "", 0},
+		{". " + dir + "/ret; echo $?", "a\n3\n", "", 0},
+		{". " + dir + "/exit; echo no", "", "", 4},
+		{". " + dir + "/bad", "a\n", dir + "/bad: syntax error at 2:1: unexpected token `fi'\n", 2},
+		{". " + dir + "/vars >f; cat f", "", "", 0},
+		{". " + dir + "/args x >f; cat f", "1 x\n", "", 0},
+		{"source", "", "source: filename argument required\n", 2},
+		{". nosuch", "", ".: nosuch: no such file or directory\n", 1},
+	})
+}
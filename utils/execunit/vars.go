@@ -0,0 +1,406 @@
+package execunit
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+type Var struct {
+	Value    string
+	Exported bool
+	ReadOnly bool
+}
+
+// Vars is the table of shell variables, only the exported ones are passed to
+// commands. The table of the interactive shell keeps the process environment
+// in sync with its exported variables so that PATH lookups see them.
+type Vars struct {
+	table map[string]*Var
+	sync  bool
+	// one scope per function call, the variables made local in it with the
+	// values they had before, nil for unset ones
+	scopes []map[string]*Var
+}
+
+// Variables from the environment start out exported
+func NewVars() *Vars {
+	vars := new(Vars)
+	vars.table = make(map[string]*Var)
+	vars.sync = true
+	for _, kv := range os.Environ() {
+		if name, value, ok := strings.Cut(kv, "="); ok && isParam(name) {
+			vars.table[name] = &Var{Value: value, Exported: true}
+		}
+	}
+	return vars
+}
+
+// A copy for a subshell, changes to it are not seen by the shell
+func (vars *Vars) Clone() *Vars {
+	clone := new(Vars)
+	clone.table = make(map[string]*Var, len(vars.table))
+	for name, v := range vars.table {
+		copied := *v
+		clone.table[name] = &copied
+	}
+	for _, scope := range vars.scopes {
+		cloned := make(map[string]*Var, len(scope))
+		for name, v := range scope {
+			if v != nil {
+				copied := *v
+				v = &copied
+			}
+			cloned[name] = v
+		}
+		clone.scopes = append(clone.scopes, cloned)
+	}
+	return clone
+}
+
+func (vars *Vars) Get(name string) (string, bool) {
+	if v, ok := vars.table[name]; ok {
+		return v.Value, true
+	}
+	return "", false
+}
+
+func (vars *Vars) Lookup(name string) *Var {
+	return vars.table[name]
+}
+
+// Names of all variables, sorted
+func (vars *Vars) Names() []string {
+	return slices.Sorted(maps.Keys(vars.table))
+}
+
+func readOnlyError(name string) error {
+	return fmt.Errorf("dlsh: %s: readonly variable", name)
+}
+
+func (vars *Vars) Set(name, value string) error {
+	v, ok := vars.table[name]
+	if !ok {
+		v = new(Var)
+		vars.table[name] = v
+	} else if v.ReadOnly {
+		return readOnlyError(name)
+	}
+	v.Value = value
+	vars.update(name)
+	return nil
+}
+
+func (vars *Vars) Export(name string, exported bool) {
+	v, ok := vars.table[name]
+	if !ok {
+		v = new(Var)
+		vars.table[name] = v
+	}
+	v.Exported = exported
+	vars.update(name)
+}
+
+func (vars *Vars) SetReadOnly(name string) {
+	v, ok := vars.table[name]
+	if !ok {
+		v = new(Var)
+		vars.table[name] = v
+	}
+	v.ReadOnly = true
+}
+
+func (vars *Vars) Unset(name string) error {
+	if v, ok := vars.table[name]; ok && v.ReadOnly {
+		return fmt.Errorf("dlsh: unset: %s: cannot unset: readonly variable", name)
+	}
+	delete(vars.table, name)
+	vars.update(name)
+	return nil
+}
+
+// Starts the scope of a function call
+func (vars *Vars) PushScope() {
+	vars.scopes = append(vars.scopes, make(map[string]*Var))
+}
+
+// Ends the scope of a function call, its local variables get back the
+// values they had before
+func (vars *Vars) PopScope() {
+	scope := vars.scopes[len(vars.scopes)-1]
+	vars.scopes = vars.scopes[:len(vars.scopes)-1]
+	for name, v := range scope {
+		if v == nil {
+			delete(vars.table, name)
+		} else {
+			vars.table[name] = v
+		}
+		vars.update(name)
+	}
+}
+
+// Makes name local to the current scope, it starts out unset. The caller's
+// variable is restored when the scope ends.
+func (vars *Vars) Local(name string) error {
+	if len(vars.scopes) == 0 {
+		return fmt.Errorf("dlsh: local: can only be used in a function")
+	}
+	v := vars.table[name]
+	if v != nil && v.ReadOnly {
+		return readOnlyError(name)
+	}
+	scope := vars.scopes[len(vars.scopes)-1]
+	if _, ok := scope[name]; !ok {
+		if v != nil {
+			saved := *v
+			v = &saved
+		}
+		scope[name] = v
+	}
+	delete(vars.table, name)
+	vars.update(name)
+	return nil
+}
+
+func (vars *Vars) update(name string) {
+	if !vars.sync {
+		return
+	}
+	if v, ok := vars.table[name]; ok && v.Exported {
+		os.Setenv(name, v.Value)
+	} else {
+		os.Unsetenv(name)
+	}
+}
+
+// Environment of a command, the exported variables as NAME=value
+func (vars *Vars) Environ() []string {
+	var env []string
+	for _, name := range vars.Names() {
+		if v := vars.table[name]; v.Exported {
+			env = append(env, name+"="+v.Value)
+		}
+	}
+	return env
+}
+
+// Reports whether word is an assignment, NAME=value
+func IsAssignment(word string) bool {
+	name, _, ok := strings.Cut(word, "=")
+	return ok && name != "" && isNameStart(name[0]) && isParam(name)
+}
+
+// A value double quoted so that the shell reads it back as is
+func quoteValue(value string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(value); i++ {
+		if strings.IndexByte("\"$`\\", value[i]) != -1 {
+			sb.WriteByte('\\')
+		}
+		sb.WriteByte(value[i])
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+func (vars *Vars) Declaration(name string) string {
+	v := vars.table[name]
+	attrs := ""
+	if v.ReadOnly {
+		attrs += "r"
+	}
+	if v.Exported {
+		attrs += "x"
+	}
+	if attrs == "" {
+		attrs = "-"
+	}
+	return fmt.Sprintf("declare -%s %s=%s", attrs, name, quoteValue(v.Value))
+}
+
+// Sets the variables given as name or name=value with attr, "x" to export
+// them and "r" to make them read-only. Without operands the variables having
+// attr are printed.
+func (dlsh *ExecUnit) declare(stdout, stderr *os.File, cmd, attr string, operands []string) int {
+	if len(operands) == 0 {
+		for _, name := range dlsh.Vars.Names() {
+			v := dlsh.Vars.Lookup(name)
+			if attr == "" || (attr == "x" && v.Exported) || (attr == "r" && v.ReadOnly) {
+				fmt.Fprintln(stdout, dlsh.Vars.Declaration(name))
+			}
+		}
+		return 0
+	}
+
+	status := 0
+	for _, operand := range operands {
+		name, value, assign := strings.Cut(operand, "=")
+		if !isParam(name) || !isNameStart(name[0]) {
+			fmt.Fprintf(stderr, "%s: `%s': not a valid identifier\n", cmd, operand)
+			status = 1
+			continue
+		}
+		if assign {
+			if err := dlsh.Vars.Set(name, value); err != nil {
+				fmt.Fprintln(stderr, err.Error())
+				status = 1
+				continue
+			}
+		}
+		switch attr {
+		case "x":
+			dlsh.Vars.Export(name, true)
+		case "r":
+			dlsh.Vars.SetReadOnly(name)
+		}
+	}
+	return status
+}
+
+// export [-n] [-p] [name[=value] ...]
+func (dlsh *ExecUnit) BuiltinExport(args []string, stdin, stdout, stderr *os.File) int {
+	args = args[1:]
+	unexport := false
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		switch args[0] {
+		case "-n":
+			unexport = true
+		case "-p":
+		default:
+			fmt.Fprintf(stderr, "export: %s: invalid option\n", args[0])
+			return 2
+		}
+		args = args[1:]
+	}
+	if unexport {
+		for _, name := range args {
+			if dlsh.Vars.Lookup(name) != nil {
+				dlsh.Vars.Export(name, false)
+			}
+		}
+		return 0
+	}
+	return dlsh.declare(stdout, stderr, "export", "x", args)
+}
+
+// readonly [-p] [name[=value] ...]
+func (dlsh *ExecUnit) BuiltinReadonly(args []string, stdin, stdout, stderr *os.File) int {
+	args = args[1:]
+	if len(args) > 0 && args[0] == "-p" {
+		args = args[1:]
+	}
+	return dlsh.declare(stdout, stderr, "readonly", "r", args)
+}
+
+// unset [-v | -f] name ...
+func (dlsh *ExecUnit) BuiltinUnset(args []string, stdin, stdout, stderr *os.File) int {
+	args = args[1:]
+	funcs := false
+	for len(args) > 0 && (args[0] == "-v" || args[0] == "-f") {
+		funcs = args[0] == "-f"
+		args = args[1:]
+	}
+	status := 0
+	for _, name := range args {
+		if funcs {
+			delete(dlsh.Funcs, name)
+			continue
+		}
+		if err := dlsh.Vars.Unset(name); err != nil {
+			fmt.Fprintln(stderr, err.Error())
+			status = 1
+		}
+	}
+	return status
+}
+
+// declare [-p] [-x] [-r] [name[=value] ...]
+func (dlsh *ExecUnit) BuiltinDeclare(args []string, stdin, stdout, stderr *os.File) int {
+	args = args[1:]
+	var print, export, readOnly bool
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		for _, opt := range args[0][1:] {
+			switch opt {
+			case 'p':
+				print = true
+			case 'x':
+				export = true
+			case 'r':
+				readOnly = true
+			default:
+				fmt.Fprintf(stderr, "declare: -%c: invalid option\n", opt)
+				return 2
+			}
+		}
+		args = args[1:]
+	}
+
+	if print || len(args) == 0 {
+		status := 0
+		if len(args) == 0 {
+			args = dlsh.Vars.Names()
+		}
+		for _, name := range args {
+			v := dlsh.Vars.Lookup(name)
+			if v == nil {
+				fmt.Fprintf(stderr, "declare: %s: not found\n", name)
+				status = 1
+			} else if (!export || v.Exported) && (!readOnly || v.ReadOnly) {
+				fmt.Fprintln(stdout, dlsh.Vars.Declaration(name))
+			}
+		}
+		return status
+	}
+
+	status := dlsh.declare(stdout, stderr, "declare", "", args)
+	for _, arg := range args {
+		name, _, _ := strings.Cut(arg, "=")
+		if dlsh.Vars.Lookup(name) == nil {
+			continue
+		}
+		if export {
+			dlsh.Vars.Export(name, true)
+		}
+		if readOnly {
+			dlsh.Vars.SetReadOnly(name)
+		}
+	}
+	return status
+}
+
+// local [name[=value] ...]
+func (dlsh *ExecUnit) BuiltinLocal(args []string, stdin, stdout, stderr *os.File) int {
+	status := 0
+	for _, operand := range args[1:] {
+		name, value, assign := strings.Cut(operand, "=")
+		if !isParam(name) || !isNameStart(name[0]) {
+			fmt.Fprintf(stderr, "local: `%s': not a valid identifier\n", operand)
+			status = 1
+			continue
+		}
+		if err := dlsh.Vars.Local(name); err != nil {
+			fmt.Fprintln(stderr, err.Error())
+			return 1
+		}
+		if assign {
+			dlsh.Vars.Set(name, value)
+		}
+	}
+	return status
+}
+
+// Makes the NAME=value assignments of a command without a name, status is
+// returned once they are made
+func (dlsh *ExecUnit) Assign(assigns []string, status int) int {
+	for _, assign := range assigns {
+		name, value, _ := strings.Cut(assign, "=")
+		if err := dlsh.Vars.Set(name, value); err != nil {
+			fmt.Fprintln(dlsh.Stderr, err.Error())
+			return 1
+		}
+	}
+	return status
+}
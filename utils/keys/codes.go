@@ -5,7 +5,11 @@ const (
 	CtrlB         uint8 = 0x2
 	CtrlC         uint8 = 0x3
 	CtrlD         uint8 = 0x4
+	CtrlG         uint8 = 0x7
+	Tab           uint8 = 0x9
 	Enter         uint8 = 0xd
+	CtrlR         uint8 = 0x12
+	CtrlS         uint8 = 0x13
 	Escape        uint8 = 0x1b
 	OpenSqBracket uint8 = 0x5b
 	Home          uint8 = 0x31